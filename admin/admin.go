@@ -0,0 +1,357 @@
+// Package admin provides mountable Fiber routes for inspecting and controlling
+// a running fibril hub: listing clients and topics, sending messages and kicking clients.
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/lishank0119/fibril"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrAuthRequired is returned by Register when the config has neither an Auth hook nor Insecure set.
+var ErrAuthRequired = errors.New("admin: Config.Auth is required, set Config.Insecure to mount the routes without authentication")
+
+// Config holds the settings for the admin routes.
+type Config struct {
+	// Auth is called before every admin request. Returning an error rejects the
+	// request with that error (use fiber.NewError to pick the status code).
+	// Required unless Insecure is set.
+	Auth func(*fiber.Ctx) error
+
+	// Insecure allows mounting the routes without Auth, e.g. on a listener that is
+	// only reachable from a trusted network.
+	// Optional. Default: false
+	Insecure bool
+
+	// DefaultLimit is the page size used when the request does not specify one.
+	// Optional. Default: 50
+	DefaultLimit int
+
+	// MaxLimit caps the page size a request may ask for.
+	// Optional. Default: 1000
+	MaxLimit int
+}
+
+// ClientInfo describes a connected client.
+type ClientInfo struct {
+	UUID          string            `json:"uuid"`
+	State         string            `json:"state"`
+	RemoteAddr    string            `json:"remote_addr"`
+	RealIP        string            `json:"real_ip"`
+	ConnectedAt   time.Time         `json:"connected_at"`
+	Keys          map[string]string `json:"keys"`
+	Subscriptions []string          `json:"subscriptions"`
+	QueueDepth    int               `json:"queue_depth"`
+	RTTMs         float64           `json:"rtt_ms"`
+	RTTAvgMs      float64           `json:"rtt_avg_ms"`
+	JitterMs      float64           `json:"jitter_ms"`
+}
+
+// TopicInfo describes an active topic.
+type TopicInfo struct {
+	Topic       string `json:"topic"`
+	Subscribers int    `json:"subscribers"`
+}
+
+// MessageRequest is the body of the send, publish and broadcast routes.
+// Exactly one of Text or Binary (base64 encoded in JSON) must be set.
+type MessageRequest struct {
+	Text   *string `json:"text"`
+	Binary []byte  `json:"binary"`
+}
+
+// DisconnectRequest is the body of the disconnect routes.
+type DisconnectRequest struct {
+	UUIDs  []string `json:"uuids"`
+	Reason string   `json:"reason"`
+}
+
+// Register mounts the admin routes on router:
+//
+//	GET    /clients                   list clients (?offset=&limit=&key.<name>=<value>)
+//	GET    /clients/:uuid             get a client
+//	POST   /clients/:uuid/send        send a message to a client
+//	POST   /clients/:uuid/disconnect  kick a client
+//	POST   /disconnect                kick several clients
+//	GET    /topics                    list topics with subscriber counts
+//	POST   /topics/:topic/publish     publish a message to a topic
+//	POST   /broadcast                 broadcast a message to all clients
+//
+// The Auth hook runs on these routes only, so router may be a group or the app itself
+// without guarding the app's other routes. Register returns ErrAuthRequired, mounting
+// nothing, if the config has no Auth hook and Insecure is not set.
+func Register(router fiber.Router, f *fibril.Fibril, config ...Config) error {
+	cfg := Config{}
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	if cfg.Auth == nil && !cfg.Insecure {
+		return ErrAuthRequired
+	}
+	if cfg.DefaultLimit <= 0 {
+		cfg.DefaultLimit = 50
+	}
+	if cfg.MaxLimit <= 0 {
+		cfg.MaxLimit = 1000
+	}
+
+	h := &handler{f: f, cfg: cfg}
+
+	router.Get("/clients", h.auth, h.listClients)
+	router.Get("/clients/:uuid", h.auth, h.getClient)
+	router.Post("/clients/:uuid/send", h.auth, h.sendToClient)
+	router.Post("/clients/:uuid/disconnect", h.auth, h.disconnectClient)
+	router.Post("/disconnect", h.auth, h.disconnectClients)
+	router.Get("/topics", h.auth, h.listTopics)
+	router.Post("/topics/:topic/publish", h.auth, h.publish)
+	router.Post("/broadcast", h.auth, h.broadcast)
+	return nil
+}
+
+// handler implements the admin routes.
+type handler struct {
+	f   *fibril.Fibril
+	cfg Config
+}
+
+// auth runs the configured auth hook before an admin route.
+func (h *handler) auth(c *fiber.Ctx) error {
+	if h.cfg.Auth != nil {
+		if err := h.cfg.Auth(c); err != nil {
+			return err
+		}
+	}
+	return c.Next()
+}
+
+// listClients returns a page of clients, optionally filtered by key values.
+func (h *handler) listClients(c *fiber.Ctx) error {
+	offset := c.QueryInt("offset", 0)
+	limit := c.QueryInt("limit", h.cfg.DefaultLimit)
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 || limit > h.cfg.MaxLimit {
+		limit = h.cfg.MaxLimit
+	}
+
+	filters := make(map[string]string)
+	for k, v := range c.Queries() {
+		if name, ok := strings.CutPrefix(k, "key."); ok {
+			filters[name] = v
+		}
+	}
+
+	var clients []ClientInfo
+	h.f.ForEachClient(func(uuid string, client *fibril.Client) {
+		info := clientInfo(client)
+		for name, want := range filters {
+			if got, ok := info.Keys[name]; !ok || got != want {
+				return
+			}
+		}
+		clients = append(clients, info)
+	})
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ConnectedAt.Before(clients[j].ConnectedAt)
+	})
+
+	total := len(clients)
+	end := min(offset+limit, total)
+	page := []ClientInfo{}
+	if offset < total {
+		page = clients[offset:end]
+	}
+
+	return c.JSON(fiber.Map{
+		"total":   total,
+		"offset":  offset,
+		"limit":   limit,
+		"clients": page,
+	})
+}
+
+// getClient returns a single client.
+func (h *handler) getClient(c *fiber.Ctx) error {
+	client, ok := h.f.GetClient(c.Params("uuid"))
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, fibril.ErrClientNotFound.Error())
+	}
+	return c.JSON(clientInfo(client))
+}
+
+// sendToClient sends a text or binary message to a single client.
+func (h *handler) sendToClient(c *fiber.Ctx) error {
+	req, err := parseMessage(c)
+	if err != nil {
+		return err
+	}
+
+	uuid := c.Params("uuid")
+	if req.Text != nil {
+		err = h.f.SendTextToClient(uuid, *req.Text)
+	} else {
+		err = h.f.SendBinaryToClient(uuid, req.Binary)
+	}
+	if err != nil {
+		return sendError(err)
+	}
+	return c.SendStatus(fiber.StatusAccepted)
+}
+
+// disconnectClient kicks a single client.
+func (h *handler) disconnectClient(c *fiber.Ctx) error {
+	var req DisconnectRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+	}
+	if err := h.f.DisconnectClient(req.Reason, c.Params("uuid")); err != nil {
+		return sendError(err)
+	}
+	return c.SendStatus(fiber.StatusAccepted)
+}
+
+// sendError maps an error of a send or disconnect to an HTTP error: 404 for unknown
+// clients, 504 for cluster timeouts and 502 for other failures to reach another node.
+func sendError(err error) error {
+	switch {
+	case errors.Is(err, fibril.ErrClientNotFound):
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return fiber.NewError(fiber.StatusGatewayTimeout, err.Error())
+	default:
+		return fiber.NewError(fiber.StatusBadGateway, err.Error())
+	}
+}
+
+// disconnectClients kicks the listed clients and reports the ones that were not
+// found and the ones that could not be reached with the error.
+func (h *handler) disconnectClients(c *fiber.Ctx) error {
+	var req DisconnectRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	disconnected := []string{}
+	notFound := []string{}
+	failed := map[string]string{}
+	for _, uuid := range req.UUIDs {
+		err := h.f.DisconnectClient(req.Reason, uuid)
+		switch {
+		case err == nil:
+			disconnected = append(disconnected, uuid)
+		case errors.Is(err, fibril.ErrClientNotFound):
+			notFound = append(notFound, uuid)
+		default:
+			failed[uuid] = err.Error()
+		}
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"disconnected": disconnected,
+		"not_found":    notFound,
+		"failed":       failed,
+	})
+}
+
+// listTopics returns all active topics with their subscriber counts.
+func (h *handler) listTopics(c *fiber.Ctx) error {
+	topics := []TopicInfo{}
+	for _, topic := range h.f.ListTopics() {
+		topics = append(topics, TopicInfo{Topic: topic, Subscribers: h.f.SubscriberCount(topic)})
+	}
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i].Topic < topics[j].Topic
+	})
+	return c.JSON(topics)
+}
+
+// publish publishes a message to a topic.
+func (h *handler) publish(c *fiber.Ctx) error {
+	req, err := parseMessage(c)
+	if err != nil {
+		return err
+	}
+
+	msg := req.Binary
+	if req.Text != nil {
+		msg = []byte(*req.Text)
+	}
+	if err := h.f.Publish(c.Params("topic"), msg); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+	return c.SendStatus(fiber.StatusAccepted)
+}
+
+// broadcast sends a message to all connected clients.
+func (h *handler) broadcast(c *fiber.Ctx) error {
+	req, err := parseMessage(c)
+	if err != nil {
+		return err
+	}
+
+	if req.Text != nil {
+		h.f.BroadcastText(*req.Text)
+	} else {
+		h.f.BroadcastBinary(req.Binary)
+	}
+	return c.SendStatus(fiber.StatusAccepted)
+}
+
+// parseMessage decodes and validates a MessageRequest body.
+func parseMessage(c *fiber.Ctx) (*MessageRequest, error) {
+	var req MessageRequest
+	if err := c.BodyParser(&req); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if (req.Text == nil) == (req.Binary == nil) {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "exactly one of text or binary is required")
+	}
+	return &req, nil
+}
+
+// clientInfo builds the JSON view of a client.
+func clientInfo(client *fibril.Client) ClientInfo {
+	keys := make(map[string]string)
+	for k, v := range client.Keys() {
+		keys[fmt.Sprint(k)] = fmt.Sprint(v)
+	}
+
+	subscriptions := client.Subscriptions()
+	if subscriptions == nil {
+		subscriptions = []string{}
+	}
+	sort.Strings(subscriptions)
+
+	info := ClientInfo{
+		UUID:          client.GetUUID(),
+		State:         client.State().String(),
+		ConnectedAt:   client.ConnectedAt(),
+		Keys:          keys,
+		Subscriptions: subscriptions,
+		QueueDepth:    client.QueueLen(),
+	}
+	rtt := client.RTTStats()
+	info.RTTMs = milliseconds(rtt.Last)
+	info.RTTAvgMs = milliseconds(rtt.Avg)
+	info.JitterMs = milliseconds(rtt.Jitter)
+	if addr := client.RemoteAddr(); addr != nil {
+		info.RemoteAddr = addr.String()
+	}
+	if hs := client.Handshake(); hs != nil {
+		info.RealIP = hs.RealIP()
+	}
+	return info
+}
+
+// milliseconds converts d to fractional milliseconds for JSON output.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,197 @@
+package admin
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/lishank0119/fibril"
+)
+
+// request sends an admin request to app and returns the status and decoded JSON body.
+func request(t *testing.T, app *fiber.App, method, path, body string, out any) int {
+	t.Helper()
+
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer secret")
+	resp, err := app.Test(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < http.StatusMultipleChoices {
+		data, _ := io.ReadAll(resp.Body)
+		if err := json.Unmarshal(data, out); err != nil {
+			t.Fatalf("%s %s: %v in %s", method, path, err, data)
+		}
+	}
+	return resp.StatusCode
+}
+
+// adminApp mounts the admin routes for f, requiring the "Bearer secret" token.
+func adminApp(t *testing.T, f *fibril.Fibril) *fiber.App {
+	t.Helper()
+
+	app := fiber.New()
+	err := Register(app, f, Config{Auth: func(c *fiber.Ctx) error {
+		if c.Get("Authorization") != "Bearer secret" {
+			return fiber.ErrUnauthorized
+		}
+		return nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+// connect serves f and connects a client as user, returning the connection and its UUID.
+func connect(t *testing.T, f *fibril.Fibril, uuids chan string, user string) (*fasthttpws.Conn, string) {
+	t.Helper()
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/ws", f.HandlerWithKeys(func(c *fiber.Ctx) map[any]any {
+		return map[any]any{"user": c.Query("user")}
+	}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = app.Listener(ln) }()
+	t.Cleanup(func() { _ = app.Shutdown() })
+
+	conn, _, err := fasthttpws.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/ws?user="+user, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn, <-uuids
+}
+
+func TestRegisterRequiresAuth(t *testing.T) {
+	f := fibril.New()
+	if err := Register(fiber.New(), f); err != ErrAuthRequired {
+		t.Fatalf("Register without Auth = %v, want ErrAuthRequired", err)
+	}
+	if err := Register(fiber.New(), f, Config{Insecure: true}); err != nil {
+		t.Fatalf("Register with Insecure = %v", err)
+	}
+}
+
+func TestAuthGuardsAdminRoutesOnly(t *testing.T) {
+	app := adminApp(t, fibril.New())
+	app.Get("/health", func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+	tests := []struct {
+		path   string
+		token  bool
+		status int
+	}{
+		{"/health", false, http.StatusOK},
+		{"/clients", false, http.StatusUnauthorized},
+		{"/topics", false, http.StatusUnauthorized},
+		{"/clients", true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.token {
+			r.Header.Set("Authorization", "Bearer secret")
+		}
+		resp, err := app.Test(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("GET %s (token %t) = %d, want %d", tt.path, tt.token, resp.StatusCode, tt.status)
+		}
+	}
+}
+
+func TestClientRoutes(t *testing.T) {
+	f := fibril.New()
+	uuids := make(chan string, 2)
+	f.ConnectHandler(func(c *fibril.Client) {
+		c.SubscribeText("news")
+		uuids <- c.GetUUID()
+	})
+	app := adminApp(t, f)
+	alice, aliceID := connect(t, f, uuids, "alice")
+	bob, bobID := connect(t, f, uuids, "bob")
+
+	var page struct {
+		Total   int          `json:"total"`
+		Clients []ClientInfo `json:"clients"`
+	}
+	if status := request(t, app, http.MethodGet, "/clients?key.user=alice", "", &page); status != http.StatusOK {
+		t.Fatalf("list clients = %d", status)
+	}
+	if page.Total != 1 || page.Clients[0].UUID != aliceID || page.Clients[0].Keys["user"] != "alice" {
+		t.Fatalf("clients filtered by user=alice = %+v", page)
+	}
+	if request(t, app, http.MethodGet, "/clients?limit=1&offset=1", "", &page); page.Total != 2 || len(page.Clients) != 1 {
+		t.Fatalf("second page of one = %+v, want one of two clients", page)
+	}
+
+	var info ClientInfo
+	if status := request(t, app, http.MethodGet, "/clients/"+bobID, "", &info); status != http.StatusOK || info.Subscriptions[0] != "news" {
+		t.Fatalf("get client = %d %+v", status, info)
+	}
+	if status := request(t, app, http.MethodGet, "/clients/missing", "", nil); status != http.StatusNotFound {
+		t.Fatalf("get unknown client = %d, want 404", status)
+	}
+
+	var topics []TopicInfo
+	request(t, app, http.MethodGet, "/topics", "", &topics)
+	if len(topics) != 1 || topics[0] != (TopicInfo{Topic: "news", Subscribers: 2}) {
+		t.Fatalf("topics = %+v", topics)
+	}
+
+	messages := []struct {
+		path, body string
+		status     int
+	}{
+		{"/clients/" + aliceID + "/send", `{"text":"to alice"}`, http.StatusAccepted},
+		{"/clients/missing/send", `{"text":"x"}`, http.StatusNotFound},
+		{"/clients/" + aliceID + "/send", `{}`, http.StatusBadRequest},
+		{"/clients/" + aliceID + "/send", `{"text":"x","binary":"eA=="}`, http.StatusBadRequest},
+		{"/broadcast", `{"text":"to all"}`, http.StatusAccepted},
+	}
+	for _, m := range messages {
+		if status := request(t, app, http.MethodPost, m.path, m.body, nil); status != m.status {
+			t.Fatalf("POST %s %s = %d, want %d", m.path, m.body, status, m.status)
+		}
+	}
+	for _, want := range []string{"to alice", "to all"} {
+		_ = alice.SetReadDeadline(time.Now().Add(time.Second))
+		if _, msg, err := alice.ReadMessage(); err != nil || string(msg) != want {
+			t.Fatalf("alice received %q, %v, want %q", msg, err, want)
+		}
+	}
+
+	var result struct {
+		Disconnected []string `json:"disconnected"`
+		NotFound     []string `json:"not_found"`
+	}
+	status := request(t, app, http.MethodPost, "/disconnect", `{"uuids":["`+bobID+`","missing"],"reason":"bye"}`, &result)
+	if status != http.StatusAccepted || len(result.Disconnected) != 1 || result.NotFound[0] != "missing" {
+		t.Fatalf("disconnect = %d %+v", status, result)
+	}
+
+	_ = bob.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		if _, _, err := bob.ReadMessage(); err != nil {
+			if !fasthttpws.IsCloseError(err, fasthttpws.CloseNormalClosure) {
+				t.Fatalf("bob's connection ended with %v, want a normal close", err)
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package fibril
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// reservationTimeout is how long a slot reserved by Handler waits for its client to
+// register before it is released.
+const reservationTimeout = 10 * time.Second
+
+// admission enforces the global and per-IP connection caps and the
+// connection-accept rate limit. Slots are reserved before a client is
+// registered and released when it is destroyed.
+type admission struct {
+	mu       sync.Mutex
+	total    int            // Number of reserved connection slots
+	perIP    map[string]int // Number of reserved slots per client IP
+	maxTotal int            // Maximum number of concurrent connections, 0 means unlimited
+	maxPerIP int            // Maximum number of concurrent connections per IP, 0 means unlimited
+	bucket   *tokenBucket   // Connection-accept rate limiter, nil means unlimited
+}
+
+// acquire reserves a connection slot for the given IP, or returns
+// ErrTooManyConnections or ErrRateLimited if the connection must be refused.
+func (a *admission) acquire(ip string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.maxTotal > 0 && a.total >= a.maxTotal {
+		return ErrTooManyConnections
+	}
+	if a.maxPerIP > 0 && a.perIP[ip] >= a.maxPerIP {
+		return ErrTooManyConnectionsPerIP
+	}
+	if a.bucket != nil && !a.bucket.allow(time.Now()) {
+		return ErrRateLimited
+	}
+
+	a.total++
+	a.perIP[ip]++
+	return nil
+}
+
+// release frees a slot previously reserved for the given IP.
+func (a *admission) release(ip string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.total--
+	if n := a.perIP[ip] - 1; n > 0 {
+		a.perIP[ip] = n
+	} else {
+		delete(a.perIP, ip)
+	}
+}
+
+// reservation is an admission slot reserved by Handler before the upgrade. The client
+// claims it when it registers. If the connection handler never runs, for example because
+// a websocket.Config Filter passed the request on or the hijacked connection failed
+// before the handler started, the slot is released by Handler or after a timeout.
+type reservation struct {
+	admission *admission
+	ip        string
+	done      atomic.Bool // Whether the slot was claimed or released
+	timer     *time.Timer // Releases the slot if it is not claimed in time
+}
+
+// reserve acquires a slot for ip that is released after timeout unless claimed.
+func (a *admission) reserve(ip string, timeout time.Duration) (*reservation, error) {
+	if err := a.acquire(ip); err != nil {
+		return nil, err
+	}
+	r := &reservation{admission: a, ip: ip}
+	r.timer = time.AfterFunc(timeout, r.expire)
+	return r, nil
+}
+
+// claim takes ownership of the slot and reports whether it was still reserved.
+func (r *reservation) claim() bool {
+	if !r.done.CompareAndSwap(false, true) {
+		return false
+	}
+	r.timer.Stop()
+	return true
+}
+
+// release frees the slot unless it was claimed.
+func (r *reservation) release() {
+	if r.claim() {
+		r.admission.release(r.ip)
+	}
+}
+
+// expire frees the slot from the timer unless it was claimed. Unlike release, it does
+// not touch the timer, which may not have been stored yet.
+func (r *reservation) expire() {
+	if r.done.CompareAndSwap(false, true) {
+		r.admission.release(r.ip)
+	}
+}
+
+// configure applies changed limits from opt. Reserved slots are kept, so lowering
+// a cap only refuses new connections until enough clients have left.
+func (a *admission) configure(opt *option) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.maxTotal = opt.maxConnections
+	a.maxPerIP = opt.maxConnectionsPerIP
+	switch {
+	case opt.acceptRate <= 0:
+		a.bucket = nil
+	case a.bucket == nil:
+		a.bucket = newTokenBucket(opt.acceptRate, opt.acceptBurst)
+	default:
+		a.bucket.rate = opt.acceptRate
+		a.bucket.burst = float64(opt.acceptBurst)
+		a.bucket.tokens = min(a.bucket.tokens, a.bucket.burst)
+	}
+}
+
+// tokenBucket is a minimal token-bucket rate limiter. It is not safe for
+// concurrent use; admission guards it with its own mutex.
+type tokenBucket struct {
+	rate   float64   // Tokens added per second
+	burst  float64   // Maximum number of tokens
+	tokens float64   // Currently available tokens
+	last   time.Time // Last refill time
+}
+
+// allow consumes a token if one is available at the given time.
+func (b *tokenBucket) allow(now time.Time) bool {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// newAdmission creates the admission controller from the configured limits.
+func newAdmission(opt *option) *admission {
+	a := &admission{
+		perIP:    make(map[string]int),
+		maxTotal: opt.maxConnections,
+		maxPerIP: opt.maxConnectionsPerIP,
+	}
+	if opt.acceptRate > 0 {
+		a.bucket = newTokenBucket(opt.acceptRate, opt.acceptBurst)
+	}
+	return a
+}
+
+// newTokenBucket creates a full token bucket.
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
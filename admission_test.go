@@ -0,0 +1,142 @@
+package fibril
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// dialStatus attempts an upgrade and returns the HTTP status of a refused handshake,
+// or 101 with the connection closed at the end of the test.
+func dialStatus(t *testing.T, url string) (int, http.Header) {
+	t.Helper()
+
+	conn, resp, err := fasthttpws.DefaultDialer.Dial(url, nil)
+	if err == nil {
+		t.Cleanup(func() { _ = conn.Close() })
+		return http.StatusSwitchingProtocols, resp.Header
+	}
+	if resp == nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, resp.Header
+}
+
+// reservedSlots returns the number of admission slots currently reserved.
+func reservedSlots(a *admission) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.total
+}
+
+func TestAdmissionLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		opt        OptFunc
+		status     int
+		retryAfter string
+	}{
+		{"per-ip", WithMaxConnectionsPerIP(1), http.StatusTooManyRequests, ""},
+		{"global", WithMaxConnections(1), http.StatusServiceUnavailable, "1"},
+		{"accept-rate", WithAcceptRate(0.001, 1), http.StatusServiceUnavailable, "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := New(tt.opt)
+			url := serve(t, f.Handler())
+
+			first := dial(t, url, "a")
+			if status, header := dialStatus(t, url); status != tt.status || header.Get("Retry-After") != tt.retryAfter {
+				t.Fatalf("second upgrade = %d (Retry-After %q), want %d (%q)",
+					status, header.Get("Retry-After"), tt.status, tt.retryAfter)
+			}
+			if n := reservedSlots(f.hub.admission); n != 1 {
+				t.Fatalf("reserved slots = %d after a refused upgrade, want 1", n)
+			}
+
+			_ = first.Close()
+			eventually(t, "the slot's release", func() bool { return reservedSlots(f.hub.admission) == 0 })
+		})
+	}
+}
+
+func TestAdmissionReleasesUnusedReservations(t *testing.T) {
+	f := New(WithMaxConnections(1))
+	app := fiber.New()
+	// The filter passes every request on, so the connection handler never runs.
+	app.Get("/ws", f.Handler(websocket.Config{Filter: func(*fiber.Ctx) bool { return false }}), func(c *fiber.Ctx) error {
+		return c.SendStatus(http.StatusTeapot)
+	})
+
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		resp, err := app.Test(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusTeapot {
+			t.Fatalf("request %d = %d, want %d", i, resp.StatusCode, http.StatusTeapot)
+		}
+		if n := reservedSlots(f.hub.admission); n != 0 {
+			t.Fatalf("reserved slots = %d after request %d, want 0", n, i)
+		}
+	}
+}
+
+func TestReservationTimeout(t *testing.T) {
+	a := newAdmission(&option{maxConnections: 1})
+
+	r, err := a.reserve("10.0.0.1", 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.reserve("10.0.0.2", time.Second); err != ErrTooManyConnections {
+		t.Fatalf("second reserve = %v, want ErrTooManyConnections", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if r.claim() {
+		t.Fatal("claim succeeded after the reservation timed out")
+	}
+	r2, err := a.reserve("10.0.0.2", time.Second)
+	if err != nil {
+		t.Fatalf("reserve after timeout = %v", err)
+	}
+	if !r2.claim() {
+		t.Fatal("claim of a fresh reservation failed")
+	}
+	r2.release() // A claimed slot belongs to the client: release is a no-op.
+	if n := reservedSlots(a); n != 1 {
+		t.Fatalf("reserved slots = %d, want 1", n)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Unix(0, 0)
+	b := newTokenBucket(2, 3)
+
+	steps := []struct {
+		at   time.Duration
+		want bool
+	}{
+		{0, true}, {0, true}, {0, true}, // The burst
+		{0, false},
+		{250 * time.Millisecond, false}, // Half a token
+		{500 * time.Millisecond, true},  // One token after 0.5s at 2/s
+		{500 * time.Millisecond, false},
+		{10 * time.Second, true}, {10 * time.Second, true}, {10 * time.Second, true}, // Refilled up to the burst only
+		{10 * time.Second, false},
+	}
+	for i, s := range steps {
+		if got := b.allow(start.Add(s.at)); got != s.want {
+			t.Fatalf("step %d at %v: allow = %t, want %t", i, s.at, got, s.want)
+		}
+	}
+}
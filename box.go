@@ -1,5 +1,7 @@
 package fibril
 
+import fasthttpws "github.com/fasthttp/websocket"
+
 // filterFunc defines a function type that takes a *Client as input
 // and returns a boolean indicating whether the client meets specific criteria.
 type filterFunc func(*Client) bool
@@ -8,7 +10,30 @@ type filterFunc func(*Client) bool
 // It holds the message type, the actual message data, and an optional filter
 // to determine which clients should receive the message.
 type box struct {
-	t      int        // WebSocket message type (e.g., text or binary)
-	msg    []byte     // Actual message content
-	filter filterFunc // Optional filter to determine target clients
+	t         int                         // WebSocket message type (e.g., text or binary)
+	msg       []byte                      // Actual message content
+	filter    filterFunc                  // Optional filter to determine target clients
+	to        []*Client                   // Optional explicit recipients, used instead of scanning all clients
+	code      int                         // Close code for close messages, defaults to a normal closure
+	pm        *fasthttpws.PreparedMessage // Optional pre-framed message shared by many recipients
+	priority  Priority                    // Delivery priority, selecting the client queue
+	done      chan error                  // Optional channel receiving the write result, for senders waiting on the write
+	keepalive bool                        // Application-level heartbeat, which does not count as activity
+}
+
+// ack reports the write result to a sender waiting on it.
+func (b box) ack(err error) {
+	if b.done != nil {
+		b.done <- err
+	}
+}
+
+// prepareMessage frames msg once so the same frame can be written to many connections.
+// It returns nil if the message cannot be prepared, in which case msg is written as is.
+func prepareMessage(t int, msg []byte) *fasthttpws.PreparedMessage {
+	pm, err := fasthttpws.NewPreparedMessage(t, msg)
+	if err != nil {
+		return nil
+	}
+	return pm
 }
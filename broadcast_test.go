@@ -0,0 +1,127 @@
+package fibril
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/gofiber/contrib/websocket"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeCounter counts the writes to a set of discardConns and signals done once
+// the expected number has been reached.
+type writeCounter struct {
+	n      atomic.Int64
+	target atomic.Int64
+	done   chan struct{}
+}
+
+// expect arms the counter to signal done after n more writes.
+func (w *writeCounter) expect(n int) {
+	w.target.Store(w.n.Load() + int64(n))
+}
+
+func (w *writeCounter) add() {
+	if w.n.Add(1) == w.target.Load() {
+		w.done <- struct{}{}
+	}
+}
+
+// discardConn is a net.Conn that counts and discards everything written to it and
+// never delivers data, standing in for a client socket.
+type discardConn struct {
+	writes *writeCounter
+}
+
+func (discardConn) Read([]byte) (int, error) { select {} }
+func (c discardConn) Write(p []byte) (int, error) {
+	c.writes.add()
+	return len(p), nil
+}
+func (discardConn) Close() error                     { return nil }
+func (discardConn) LocalAddr() net.Addr              { return &net.TCPAddr{} }
+func (discardConn) RemoteAddr() net.Addr             { return &net.TCPAddr{} }
+func (discardConn) SetDeadline(time.Time) error      { return nil }
+func (discardConn) SetReadDeadline(time.Time) error  { return nil }
+func (discardConn) SetWriteDeadline(time.Time) error { return nil }
+
+// hijackRecorder lets the upgrader hijack a discardConn.
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	conn discardConn
+}
+
+func (r hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return r.conn, bufio.NewReadWriter(bufio.NewReader(r.conn), bufio.NewWriter(r.conn)), nil
+}
+
+// benchHub registers n clients writing to discardConns with a new hub, negotiating
+// permessage-deflate if compress is set, and returns the hub and the write counter.
+// The upgrade responses are counted before the counter is returned.
+func benchHub(b *testing.B, n int, compress bool) (*Fibril, *writeCounter) {
+	f := New()
+	writes := &writeCounter{done: make(chan struct{})}
+	upgrader := fasthttpws.Upgrader{
+		EnableCompression: compress,
+		CheckOrigin:       func(*http.Request) bool { return true },
+	}
+
+	for i := 0; i < n; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		if compress {
+			r.Header.Set("Sec-WebSocket-Extensions", "permessage-deflate")
+		}
+		conn, err := upgrader.Upgrade(hijackRecorder{httptest.NewRecorder(), discardConn{writes}}, r, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		conn.EnableWriteCompression(compress)
+		// newClient blocks in readPump for as long as the connection lives.
+		go newClient(f.hub, &websocket.Conn{Conn: conn}, f.hub.options(), nil)
+	}
+	for f.ClientLen() < n {
+		time.Sleep(time.Millisecond)
+	}
+	return f, writes
+}
+
+// BenchmarkBroadcast10k sends one message to 10,000 connections through the hub and
+// their write pumps. A broadcast frames the message once as a prepared message shared
+// by every connection, as SubscribeText/SubscribeBinary deliveries do, while sending
+// to each client frames it once per connection, as handler-based Subscribe deliveries
+// do. Each message reaches a discardConn in a single write, which is what is counted.
+func BenchmarkBroadcast10k(b *testing.B) {
+	const n = 10_000
+	msg := string(bytes.Repeat([]byte(`{"type":"quote","symbol":"ACME","price":123.45},`), 20))
+
+	for _, compress := range []bool{false, true} {
+		f, writes := benchHub(b, n, compress)
+		send := map[string]func(){
+			"broadcast": func() { f.BroadcastText(msg) },
+			"per-client": func() {
+				f.ForEachClient(func(_ string, c *Client) { _ = c.SendText(msg) })
+			},
+		}
+		for _, mode := range []string{"per-client", "broadcast"} {
+			b.Run(fmt.Sprintf("compress=%t/%s", compress, mode), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					writes.expect(n)
+					send[mode]()
+					<-writes.done
+				}
+				b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*n), "ns/client")
+			})
+		}
+	}
+}
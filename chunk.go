@@ -0,0 +1,61 @@
+package fibril
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Chunk flags carried in the header of every chunked transfer frame.
+const (
+	ChunkStart byte = 1 << iota // First frame of a transfer; the payload is the transfer name
+	ChunkFinal                  // Last data frame of a transfer
+	ChunkAbort                  // The transfer was canceled by the sender; discard received data
+)
+
+const (
+	chunkMagic   = 0xFB // First byte identifying a chunked transfer frame
+	chunkVersion = 1    // Version of the chunk header layout
+
+	// ChunkHeaderSize is the size in bytes of the header prefixed to every chunk frame:
+	// magic(1) version(1) flags(1) transferID(8) sequence(4) offset(8), big endian.
+	ChunkHeaderSize = 23
+)
+
+// ErrInvalidChunk is returned by ParseChunk for frames that are not chunked transfer frames.
+var ErrInvalidChunk = errors.New("invalid chunk frame")
+
+// ChunkHeader describes a single frame of a chunked binary transfer sent by Client.SendStream.
+type ChunkHeader struct {
+	TransferID uint64 // Identifies the transfer the chunk belongs to
+	Sequence   uint32 // Position of the chunk within this run of the transfer, starting at 0
+	Offset     int64  // Byte offset of the payload within the transferred data
+	Flags      byte   // Combination of ChunkStart, ChunkFinal and ChunkAbort
+}
+
+// IsChunk reports whether a binary frame is a chunked transfer frame.
+func IsChunk(frame []byte) bool {
+	return len(frame) >= ChunkHeaderSize && frame[0] == chunkMagic && frame[1] == chunkVersion
+}
+
+// ParseChunk splits a chunked transfer frame into its header and payload.
+func ParseChunk(frame []byte) (ChunkHeader, []byte, error) {
+	if !IsChunk(frame) {
+		return ChunkHeader{}, nil, ErrInvalidChunk
+	}
+	h := ChunkHeader{
+		Flags:      frame[2],
+		TransferID: binary.BigEndian.Uint64(frame[3:11]),
+		Sequence:   binary.BigEndian.Uint32(frame[11:15]),
+		Offset:     int64(binary.BigEndian.Uint64(frame[15:23])),
+	}
+	return h, frame[ChunkHeaderSize:], nil
+}
+
+// appendChunk encodes a chunk header followed by payload.
+func appendChunk(dst []byte, h ChunkHeader, payload []byte) []byte {
+	dst = append(dst, chunkMagic, chunkVersion, h.Flags)
+	dst = binary.BigEndian.AppendUint64(dst, h.TransferID)
+	dst = binary.BigEndian.AppendUint32(dst, h.Sequence)
+	dst = binary.BigEndian.AppendUint64(dst, uint64(h.Offset))
+	return append(dst, payload...)
+}
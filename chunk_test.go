@@ -0,0 +1,41 @@
+package fibril
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChunkRoundTrip(t *testing.T) {
+	h := ChunkHeader{TransferID: 0x0102030405060708, Sequence: 7, Offset: 1 << 40, Flags: ChunkFinal}
+	frame := appendChunk(nil, h, []byte("payload"))
+	if len(frame) != ChunkHeaderSize+len("payload") {
+		t.Fatalf("frame length = %d, want %d", len(frame), ChunkHeaderSize+len("payload"))
+	}
+
+	got, payload, err := ParseChunk(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != h || string(payload) != "payload" {
+		t.Fatalf("ParseChunk = %+v, %q, want %+v, %q", got, payload, h, "payload")
+	}
+}
+
+func TestParseChunkInvalid(t *testing.T) {
+	valid := appendChunk(nil, ChunkHeader{TransferID: 1}, nil)
+	tests := map[string][]byte{
+		"empty":   nil,
+		"short":   valid[:ChunkHeaderSize-1],
+		"magic":   append([]byte{0x00}, valid[1:]...),
+		"version": append([]byte{chunkMagic, chunkVersion + 1}, valid[2:]...),
+		"text":    bytes.Repeat([]byte("x"), ChunkHeaderSize),
+	}
+	for name, frame := range tests {
+		if IsChunk(frame) {
+			t.Errorf("%s: IsChunk = true", name)
+		}
+		if _, _, err := ParseChunk(frame); err != ErrInvalidChunk {
+			t.Errorf("%s: ParseChunk error = %v, want ErrInvalidChunk", name, err)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package fibril
 
 import (
+	"context"
+	"errors"
+	fasthttpws "github.com/fasthttp/websocket"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/google/uuid"
 	"github.com/lishank0119/pubsub"
@@ -12,16 +15,45 @@ import (
 
 // Client represents a WebSocket client connection.
 type Client struct {
-	uuid string             // Unique identifier for the client
-	hub  *Hub               // Reference to the Hub managing this client
-	conn *websocket.Conn    // The WebSocket connection
-	send chan box           // Channel for sending messages to the client
-	open atomic.Bool        // Indicates if the connection is open
-	opt  *option            // Configuration options for the client
-	exit chan bool          // Channel to signal the client to exit
-	keys sync.Map           // Key-value store for custom client data
-	once sync.Once          // Ensures the close operation is performed only once
-	sub  *pubsub.Subscriber // Subscriber for Pub/Sub messages
+	uuid           string                   // Unique identifier for the client
+	hub            *Hub                     // Reference to the Hub managing this client
+	conn           *websocket.Conn          // The WebSocket connection
+	queues         [priorityLevels]chan box // Per-priority channels for sending messages to the client
+	control        chan box                 // Control lane for close frames, drained before all data except queued PriorityHigh messages
+	open           atomic.Bool              // Indicates if the connection is open
+	state          atomic.Int32             // Current ConnState of the client
+	exit           chan bool                // Channel to signal the client to exit
+	written        chan struct{}            // Closed when writePump returns
+	reconfigured   chan struct{}            // Signals writePump that Reconfigure replaced the options
+	keys           sync.Map                 // Key-value store for custom client data
+	once           sync.Once                // Ensures the close operation is performed only once
+	sub            *pubsub.Subscriber       // Subscriber for Pub/Sub messages
+	ctx            context.Context          // Context canceled when the client disconnects
+	cancel         context.CancelCauseFunc  // Cancels ctx with the disconnect cause
+	cause          error                    // First recorded reason for the disconnect
+	causeOnce      sync.Once                // Ensures only the first disconnect cause is recorded
+	handshake      *Handshake               // Snapshot of the upgrade request
+	indexed        bool                     // Whether the client is present in the key index, guarded by the index lock
+	connectedAt    time.Time                // Time the client connected
+	admitted       bool                     // Whether the client holds an admission slot
+	topics         sync.Map                 // Topics the client has subscribed to
+	batch          []box                    // Reusable buffer for messages drained by writePump
+	coalesce       atomic.Bool              // Whether consecutive JSON text messages are coalesced into arrays
+	streamDeadline time.Time                // Read deadline of the inbound stream in progress, zero if none
+	protocol       *Protocol                // Handler set and codec of the negotiated subprotocol, nil if none
+	recorder       *recorder                // Traffic recording of the connection, nil if not recorded
+	rtt            rttTracker               // Round-trip time measured from keep-alive pings
+	appHeartbeat   *AppHeartbeat            // Application-level heartbeat settings, nil if disabled
+	replies        chan struct{}            // Signals heartbeat replies to heartbeatLoop
+	lastActivity   atomic.Int64             // Unix time in nanoseconds of the last application message in either direction
+	timerMu        sync.Mutex               // Guards the idle and lifetime timers
+	idleTimer      *time.Timer              // Checks for idleness, nil without an idle timeout
+	lifetimeTimer  *time.Timer              // Ends the connection at its maximum lifetime, nil without one
+	stopped        bool                     // Whether the timers were stopped, guarded by timerMu
+	lifetimeJitter time.Duration            // Random extra lifetime of the connection, drawn once, guarded by timerMu
+	jittered       bool                     // Whether lifetimeJitter was drawn, guarded by timerMu
+	pendingClose   *box                     // Close frame held back until the PriorityHigh messages queued ahead of it are written, owned by writePump
+	closeAfter     int                      // Number of PriorityHigh messages still to write before pendingClose
 }
 
 // GetUUID returns the unique identifier (UUID) of the client.
@@ -30,8 +62,104 @@ func (c *Client) GetUUID() string {
 }
 
 // Subscribe subscribes the client to a specific topic with a handler function.
+// Messages sent from the handler are framed per client; use SubscribeText or
+// SubscribeBinary to share one prepared frame among many subscribers.
 func (c *Client) Subscribe(topic string, handler pubsub.HandlerFunc) {
-	c.sub.Subscribe(topic, handler)
+	c.sub.Subscribe(topic, func(msg []byte) {
+		if _, ok := c.topics.Load(topic); ok {
+			handler(msg)
+		}
+	})
+	c.subscribed(topic)
+}
+
+// subscribed records a topic subscription and emits an EventSubscribed.
+func (c *Client) subscribed(topic string) {
+	c.topics.Store(topic, struct{}{})
+	c.hub.events.emit(Event{Type: EventSubscribed, Client: c, Topic: topic})
+}
+
+// SubscribeText subscribes the client to a topic so that published messages are
+// written to it directly as text frames. Unlike Subscribe, no handler runs per
+// client and each message is framed once for all such subscribers.
+func (c *Client) SubscribeText(topic string) {
+	c.hub.forwards.add(topic, c, websocket.TextMessage)
+	c.subscribed(topic)
+}
+
+// SubscribeBinary subscribes the client to a topic so that published messages are
+// written to it directly as binary frames, framed once for all such subscribers.
+func (c *Client) SubscribeBinary(topic string) {
+	c.hub.forwards.add(topic, c, websocket.BinaryMessage)
+	c.subscribed(topic)
+}
+
+// Unsubscribe unsubscribes the client from a topic, emits an EventUnsubscribed and
+// clears the client's presence in the topic. A handler registered with Subscribe stops
+// receiving messages at once but stays registered with the Pub/Sub broker, and counted
+// by SubscriberCount, until the client disconnects.
+func (c *Client) Unsubscribe(topic string) {
+	if _, ok := c.topics.LoadAndDelete(topic); !ok {
+		return
+	}
+	c.hub.forwards.remove(topic, c)
+	c.hub.events.emit(Event{Type: EventUnsubscribed, Client: c, Topic: topic})
+	c.ClearPresence(topic)
+}
+
+// SetCoalescing enables or disables coalescing of consecutive JSON text messages into a
+// single JSON-array frame. It only takes effect with WithWriteBatching, and only for
+// messages that are valid JSON, so enable it for clients that understand the array form.
+func (c *Client) SetCoalescing(enabled bool) {
+	c.coalesce.Store(enabled)
+}
+
+// Subscriptions returns the topics the client has subscribed to.
+func (c *Client) Subscriptions() []string {
+	var topics []string
+	c.topics.Range(func(topic, _ any) bool {
+		topics = append(topics, topic.(string))
+		return true
+	})
+	return topics
+}
+
+// QueueLen returns the number of messages waiting in the client's send queues.
+func (c *Client) QueueLen() int {
+	return c.queueLen()
+}
+
+// Context returns a context that is canceled when the client disconnects.
+// The cancellation cause can be retrieved with context.Cause or Cause.
+func (c *Client) Context() context.Context {
+	return c.ctx
+}
+
+// Done returns a channel that is closed when the client disconnects.
+func (c *Client) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+// Cause returns the reason the client disconnected, or nil while it is still connected.
+func (c *Client) Cause() error {
+	return context.Cause(c.ctx)
+}
+
+// State returns the current lifecycle state of the client.
+func (c *Client) State() ConnState {
+	return ConnState(c.state.Load())
+}
+
+// setState atomically moves the client into the given state.
+func (c *Client) setState(s ConnState) {
+	c.state.Store(int32(s))
+}
+
+// setCause records the reason for the disconnect. Only the first cause is kept.
+func (c *Client) setCause(err error) {
+	c.causeOnce.Do(func() {
+		c.cause = err
+	})
 }
 
 // isOpen checks if the client's WebSocket connection is open.
@@ -49,103 +177,247 @@ func (c *Client) RemoteAddr() net.Addr {
 	return c.conn.RemoteAddr()
 }
 
+// ConnectedAt returns the time the client connected.
+func (c *Client) ConnectedAt() time.Time {
+	return c.connectedAt
+}
+
+// optionsChanged wakes writePump to apply options replaced by Reconfigure.
+func (c *Client) optionsChanged() {
+	select {
+	case c.reconfigured <- struct{}{}:
+	default:
+	}
+}
+
+// opt returns the current configuration options, which Reconfigure may replace at any time.
+func (c *Client) opt() *option {
+	return c.hub.options()
+}
+
+// Handshake returns the snapshot of the HTTP upgrade request captured when the client connected.
+func (c *Client) Handshake() *Handshake {
+	return c.handshake
+}
+
 // GetWsConnect returns the WebSocket connection of the client.
 func (c *Client) GetWsConnect() *websocket.Conn { return c.conn }
 
 // writePump handles outgoing messages to the client and manages keep-alive pings.
 func (c *Client) writePump() {
-	ticker := time.NewTicker(c.opt.pingPeriod)
+	defer close(c.written)
+
+	period := c.opt().pingPeriod
+	ticker := time.NewTicker(period)
 	defer ticker.Stop()
 
+	// resetTicker restarts the ticker if Reconfigure changed the ping period.
+	resetTicker := func() {
+		if p := c.opt().pingPeriod; p != period {
+			period = p
+			ticker.Reset(p)
+		}
+	}
+	// tick pings the client and picks up a changed ping period.
+	tick := func() bool {
+		if !c.ping() {
+			return false
+		}
+		resetTicker()
+		return true
+	}
+
 loop:
 	for {
+		// Queues are drained in priority order, so a busy data queue must not starve pings or exit.
 		select {
-		case b, ok := <-c.send:
-			_ = c.conn.SetWriteDeadline(time.Now().Add(c.opt.writeWait))
-			if !ok {
-				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-
-			if b.t == websocket.CloseMessage {
-				if err := c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, string(b.msg))); err != nil {
-					c.opt.errorHandler(c, err)
-				}
-				time.Sleep(c.opt.disconnectDelayClose)
-				break loop
-			}
-
-			err := c.conn.WriteMessage(b.t, b.msg)
-			if err != nil {
-				c.opt.errorHandler(c, err)
-				return
-			}
-
 		case <-ticker.C:
-			_ = c.conn.SetWriteDeadline(time.Now().Add(c.opt.writeWait))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if !tick() {
 				return
 			}
-
+			continue
+		case <-c.reconfigured:
+			resetTicker()
+			continue
 		case _, ok := <-c.exit:
 			if !ok {
 				break loop
 			}
+		default:
+		}
+
+		b, ok := c.next()
+		if !ok {
+			select {
+			case b = <-c.control:
+				b = c.holdClose(b)
+			case b = <-c.queues[0]:
+			case b = <-c.queues[1]:
+			case b = <-c.queues[2]:
+			case <-ticker.C:
+				if !tick() {
+					return
+				}
+				continue
+			case <-c.reconfigured:
+				resetTicker()
+				continue
+			case _, ok := <-c.exit:
+				if !ok {
+					break loop
+				}
+				continue
+			}
+		}
+
+		_ = c.conn.SetWriteDeadline(time.Now().Add(c.opt().writeWait))
+		closing, err := c.writeBatch(c.drain(b))
+		if err != nil {
+			c.reportError(err)
+			c.setCause(err)
+			return
+		}
+		if closing {
+			time.Sleep(c.opt().disconnectDelayClose)
+			break loop
 		}
 	}
 
 	c.close()
 }
 
+// ping writes a keep-alive ping and reports whether it succeeded.
+func (c *Client) ping() bool {
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.opt().writeWait))
+	return c.conn.WriteMessage(websocket.PingMessage, pingPayload(time.Now())) == nil
+}
+
 // readPump reads incoming messages from the WebSocket connection.
 func (c *Client) readPump() {
 	defer c.destroy()
 
-	c.conn.SetReadLimit(c.opt.maxMessageSize)
-	_ = c.conn.SetReadDeadline(time.Now().Add(c.opt.pongWait))
+	_ = c.conn.SetReadDeadline(time.Now().Add(c.opt().pongWait))
 
-	c.conn.SetPongHandler(func(string) error {
-		_ = c.conn.SetReadDeadline(time.Now().Add(c.opt.pongWait))
-		c.opt.pongHandler(c)
+	c.conn.SetPongHandler(func(payload string) error {
+		_ = c.conn.SetReadDeadline(c.readDeadline())
+		c.pong(payload, time.Now())
+		c.opt().pongHandler(c)
 		return nil
 	})
 
-	if c.opt.closeHandler != nil {
+	if closeHandler := c.opt().closeHandler; closeHandler != nil {
 		c.conn.SetCloseHandler(func(code int, text string) error {
-			return c.opt.closeHandler(c, code, text)
+			return closeHandler(c, code, text)
 		})
 	}
 
+	var limit int64
 	for {
-		t, message, err := c.conn.ReadMessage()
+		// Size limits changed by Reconfigure apply from the next message.
+		streaming := c.opt().binaryStreamHandler != nil
+		if l := c.readLimit(streaming); l != limit {
+			c.conn.SetReadLimit(l)
+			limit = l
+		}
+
+		var (
+			t       int
+			message []byte
+			err     error
+		)
+		if streaming {
+			t, message, err = c.readMessageOrStream()
+		} else if t, message, err = c.conn.ReadMessage(); err == nil && int64(len(message)) > c.opt().maxMessageSize {
+			// The limit was lowered while the message was being read.
+			_ = c.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseMessageTooBig, ""), time.Now().Add(c.opt().writeWait))
+			err = fasthttpws.ErrReadLimit
+		}
 
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure, websocket.CloseNormalClosure) {
-				c.opt.errorHandler(c, err)
+				c.reportError(err)
 			}
+			c.setCause(err)
 			break
 		}
 
+		if t != 0 {
+			c.recordFrame(RecordInbound, t, message)
+		}
+
+		if (t == websocket.TextMessage || t == websocket.BinaryMessage) && c.heartbeatReply(message) {
+			continue
+		}
+
+		if t != 0 {
+			c.touch()
+		}
+
 		switch t {
 		case websocket.TextMessage:
-			c.opt.textMessageHandler(c, string(message))
+			c.onText(string(message))
 		case websocket.BinaryMessage:
-			c.opt.binaryMessageHandler(c, message)
+			c.onBinary(message)
 		}
 	}
 }
 
+// readLimit returns the read limit of the connection: the message size limit, or the
+// stream size limit if larger binary messages are streamed.
+func (c *Client) readLimit(streaming bool) int64 {
+	if streaming {
+		return max(c.opt().maxMessageSize, c.opt().maxStreamSize)
+	}
+	return c.opt().maxMessageSize
+}
+
 // Disconnect initiates a graceful disconnection from the client with a close message.
 func (c *Client) Disconnect(closeMsg string) {
-	message := box{t: websocket.CloseMessage, msg: []byte(closeMsg)}
+	c.disconnect(&DisconnectError{Err: ErrServerDisconnect, Reason: closeMsg}, websocket.CloseNormalClosure, closeMsg)
+}
+
+// disconnect queues a close frame with the given code and records cause as the disconnect reason.
+func (c *Client) disconnect(cause error, code int, closeMsg string) {
+	c.setCause(cause)
+	message := box{t: websocket.CloseMessage, msg: []byte(closeMsg), code: code}
 	c.writeMessage(message)
+	c.state.CompareAndSwap(int32(StateOpen), int32(StateClosing))
+}
+
+// reject refuses a client that was never registered, writing the close frame
+// directly since the write pump has not been started.
+func (c *Client) reject(cause error, code int, closeMsg string) {
+	c.setState(StateClosing)
+	c.setCause(cause)
+	c.cancel(c.cause)
+	c.sub.UnsubscribeAll()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.opt().writeWait))
+	_ = c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, closeMsg))
+	c.reportError(cause)
+	c.close()
+	c.setState(StateClosed)
 }
 
 // destroy performs cleanup operations when the client is disconnected.
+// The client context is canceled before the disconnect handler runs so
+// handlers and per-client workers observe the same cause.
 func (c *Client) destroy() {
+	c.setState(StateClosing)
+	c.setCause(ErrClientClosed)
+	c.cancel(c.cause)
+	c.stopTimers()
 	c.sub.UnsubscribeAll()
+	c.hub.forwards.removeClient(c)
+	for _, topic := range c.Subscriptions() {
+		c.hub.events.emit(Event{Type: EventUnsubscribed, Client: c, Topic: topic})
+	}
+	c.topics.Clear()
+	c.hub.publishPresence(c.hub.topicPresence.removeClient(c.GetUUID()))
 	c.hub.unregisterClient(c)
+	c.stopRecording()
 	c.close()
+	c.setState(StateClosed)
 }
 
 // close safely closes the client's WebSocket connection and signals the exit channel.
@@ -153,22 +425,53 @@ func (c *Client) close() {
 	c.once.Do(func() {
 		c.open.Store(false)
 		_ = c.conn.SetReadDeadline(time.Now())
-		err := c.conn.Close()
-		if err != nil {
-			c.opt.errorHandler(c, err)
-			return
+		if err := c.conn.Close(); err != nil {
+			c.reportError(err)
 		}
 		close(c.exit)
 	})
 }
 
 // StoreKey stores a key-value pair associated with the client.
+// Keys declared with WithIndexedKey also update the hub's secondary index.
+// Session policies and per-key limits apply as at registration, e.g. when a client
+// authenticates after connecting: other sessions may be kicked, or this client is
+// disconnected and the key is not stored.
 func (c *Client) StoreKey(key any, value any) {
+	if c.hub.index.indexed(key) {
+		old, loaded, kicked, err := c.hub.index.store(c, key, value)
+		if err != nil {
+			code, reason := rejectClose(err)
+			c.disconnect(err, code, reason)
+			return
+		}
+		c.hub.kick(kicked)
+		if c.isOpen() {
+			c.hub.cluster.register(c)
+			if loaded && old != value {
+				c.hub.announceKey(c, KeyPresenceLeave, key, old)
+			}
+			if !loaded || old != value {
+				c.hub.announceKey(c, KeyPresenceJoin, key, value)
+			}
+		}
+		return
+	}
 	c.keys.Store(key, value)
 }
 
 // DeleteKey removes a key-value pair associated with the client.
 func (c *Client) DeleteKey(key any) {
+	if c.hub.index.indexed(key) {
+		old, loaded := c.hub.index.drop(c, key)
+		if c.isOpen() {
+			c.hub.cluster.register(c)
+			if loaded {
+				c.hub.announceKey(c, KeyPresenceLeave, key, old)
+			}
+		}
+		return
+	}
 	c.keys.Delete(key)
 }
 
@@ -177,17 +480,25 @@ func (c *Client) GetKey(key any) (any, bool) {
 	return c.keys.Load(key)
 }
 
-// writeMessage sends a message to the client's send channel.
+// Keys returns a snapshot of all key-value pairs associated with the client.
+func (c *Client) Keys() map[any]any {
+	keys := make(map[any]any)
+	c.keys.Range(func(k, v any) bool {
+		keys[k] = v
+		return true
+	})
+	return keys
+}
+
+// writeMessage sends a message to the client's send queue for its priority.
 func (c *Client) writeMessage(message box) {
 	if !c.isOpen() {
-		c.opt.errorHandler(c, ErrWriteClosed)
+		c.drop(ErrWriteClosed)
 		return
 	}
 
-	select {
-	case c.send <- message:
-	default:
-		c.opt.errorHandler(c, ErrMessageBufferFull)
+	if !c.enqueue(message) {
+		c.drop(ErrMessageBufferFull)
 	}
 }
 
@@ -211,17 +522,65 @@ func (c *Client) SendBinary(msg []byte) error {
 	return nil
 }
 
+// SendTextPriority sends a text message to the client with the given delivery priority.
+func (c *Client) SendTextPriority(msg string, p Priority) error {
+	if !c.isOpen() {
+		return ErrClientClosed
+	}
+
+	c.writeMessage(box{t: websocket.TextMessage, msg: []byte(msg), priority: p})
+	return nil
+}
+
+// SendBinaryPriority sends a binary message to the client with the given delivery priority.
+func (c *Client) SendBinaryPriority(msg []byte, p Priority) error {
+	if !c.isOpen() {
+		return ErrClientClosed
+	}
+
+	c.writeMessage(box{t: websocket.BinaryMessage, msg: msg, priority: p})
+	return nil
+}
+
+// rejectClose returns the close code and message for a client refused by an
+// admission limit or session policy.
+func rejectClose(err error) (int, string) {
+	var de *DisconnectError
+	reason := err.Error()
+	if errors.As(err, &de) {
+		reason = de.Reason
+	}
+	code := websocket.CloseTryAgainLater
+	if errors.Is(err, ErrSessionRejected) {
+		code = websocket.ClosePolicyViolation
+	}
+	return code, reason
+}
+
 // newClient initializes a new WebSocket client and starts its read and write loops.
 func newClient(hub *Hub, conn *websocket.Conn, option *option, keys map[any]any) *Client {
+	ctx, cancel := context.WithCancelCause(context.Background())
 	client := &Client{
-		uuid: uuid.New().String(),
-		hub:  hub,
-		opt:  option,
-		conn: conn,
-		send: make(chan box, option.messageBufferSize),
-		sub:  hub.pubSub.NewSubscriber(),
-		exit: make(chan bool),
+		uuid:         uuid.New().String(),
+		hub:          hub,
+		conn:         conn,
+		queues:       newQueues(option.messageBufferSize),
+		control:      make(chan box, controlQueueSize),
+		sub:          hub.pubSub.NewSubscriber(),
+		exit:         make(chan bool),
+		written:      make(chan struct{}),
+		ctx:          ctx,
+		reconfigured: make(chan struct{}, 1),
+		cancel:       cancel,
+
+		connectedAt: time.Now(),
+	}
+	client.handshake = newHandshake(conn, option)
+	if option.appHeartbeat != nil {
+		client.appHeartbeat = option.appHeartbeat
+		client.replies = make(chan struct{}, 1)
 	}
+	client.protocol = option.protocols[client.handshake.Subprotocol()]
 
 	if keys != nil {
 		for k, v := range keys {
@@ -229,12 +588,26 @@ func newClient(hub *Hub, conn *websocket.Conn, option *option, keys map[any]any)
 		}
 	}
 
-	client.hub.registerClient(client)
+	if err := client.hub.registerClient(client); err != nil {
+		code, reason := rejectClose(err)
+		client.reject(err, code, reason)
+		return client
+	}
 	client.open.Store(true)
-	option.connectHandler(client)
+	client.setState(StateOpen)
+	client.startTimers()
+	client.onConnect()
+	client.hub.events.emit(Event{Type: EventConnected, Client: client})
+	client.startRecording()
 
 	go client.writePump()
+	if client.appHeartbeat != nil {
+		go client.heartbeatLoop()
+	}
 	client.readPump()
+	// The connection is released once the handler returns, so the write pump must
+	// not outlive it.
+	<-client.written
 
 	return client
 }
@@ -0,0 +1,122 @@
+// Package client contains helpers for Go programs connecting to a fibril server.
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/lishank0119/fibril"
+	"io"
+	"sync"
+)
+
+var (
+	ErrTransferAborted = errors.New("transfer aborted by sender")
+	ErrUnknownTransfer = errors.New("chunk for unknown transfer")
+)
+
+// OffsetError is returned when a chunk does not continue a transfer where the previous
+// one ended, e.g. after frames were lost. Expected is the offset to resume from.
+type OffsetError struct {
+	TransferID uint64
+	Expected   int64
+	Got        int64
+}
+
+// Error implements the error interface.
+func (e *OffsetError) Error() string {
+	return fmt.Sprintf("transfer %d: expected offset %d, got %d", e.TransferID, e.Expected, e.Got)
+}
+
+// SinkFunc opens the destination of a transfer. offset is where the sender starts,
+// which is non-zero when a transfer is resumed.
+type SinkFunc func(id uint64, name string, offset int64) (io.Writer, error)
+
+// Transfer is the receiving state of a chunked transfer.
+type Transfer struct {
+	ID     uint64    // Transfer ID chosen by the sender
+	Name   string    // Name sent in the start frame
+	Offset int64     // Number of bytes received so far, i.e. the offset to resume from
+	Done   bool      // Whether the final chunk was received
+	w      io.Writer // Destination of the received data
+	seq    uint32    // Sequence number of the last chunk received
+}
+
+// Assembler reassembles chunked transfers sent by fibril's Client.SendStream.
+// Feed it every binary frame received from the server; frames that are not chunk
+// frames are ignored so it can sit in front of a regular binary handler.
+type Assembler struct {
+	mu        sync.Mutex
+	sink      SinkFunc
+	transfers map[uint64]*Transfer
+}
+
+// NewAssembler creates an Assembler writing each transfer to the writer opened by sink.
+func NewAssembler(sink SinkFunc) *Assembler {
+	return &Assembler{sink: sink, transfers: make(map[uint64]*Transfer)}
+}
+
+// Feed processes a binary frame. It returns the transfer the frame belongs to and
+// whether the frame was a chunk frame at all. Completed and aborted transfers are
+// forgotten; interrupted ones keep their offset so they can be resumed.
+func (a *Assembler) Feed(frame []byte) (*Transfer, bool, error) {
+	h, payload, err := fibril.ParseChunk(frame)
+	if err != nil {
+		return nil, false, nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	t, ok := a.transfers[h.TransferID]
+	switch {
+	case h.Flags&fibril.ChunkAbort != 0:
+		delete(a.transfers, h.TransferID)
+		return t, true, ErrTransferAborted
+
+	case h.Flags&fibril.ChunkStart != 0:
+		if ok && t.Offset != h.Offset {
+			return t, true, &OffsetError{TransferID: h.TransferID, Expected: t.Offset, Got: h.Offset}
+		}
+		if !ok {
+			w, err := a.sink(h.TransferID, string(payload), h.Offset)
+			if err != nil {
+				return nil, true, err
+			}
+			t = &Transfer{ID: h.TransferID, Name: string(payload), Offset: h.Offset, w: w}
+			a.transfers[h.TransferID] = t
+		}
+		t.seq = h.Sequence
+		return t, true, nil
+
+	case !ok:
+		return nil, true, ErrUnknownTransfer
+	}
+
+	if h.Sequence != t.seq+1 || h.Offset != t.Offset {
+		return t, true, &OffsetError{TransferID: h.TransferID, Expected: t.Offset, Got: h.Offset}
+	}
+	if _, err := t.w.Write(payload); err != nil {
+		return t, true, err
+	}
+	t.seq = h.Sequence
+	t.Offset += int64(len(payload))
+
+	if h.Flags&fibril.ChunkFinal != 0 {
+		t.Done = true
+		delete(a.transfers, h.TransferID)
+	}
+	return t, true, nil
+}
+
+// Pending returns the transfers that have started but not completed, e.g. to request
+// a resume from their Offset after reconnecting.
+func (a *Assembler) Pending() []Transfer {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	pending := make([]Transfer, 0, len(a.transfers))
+	for _, t := range a.transfers {
+		pending = append(pending, *t)
+	}
+	return pending
+}
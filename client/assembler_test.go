@@ -0,0 +1,116 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/lishank0119/fibril"
+)
+
+// chunk encodes a chunk frame the way fibril's Client.SendStream does.
+func chunk(id uint64, seq uint32, offset int64, flags byte, payload string) []byte {
+	frame := []byte{0xFB, 1, flags}
+	frame = binary.BigEndian.AppendUint64(frame, id)
+	frame = binary.BigEndian.AppendUint32(frame, seq)
+	frame = binary.BigEndian.AppendUint64(frame, uint64(offset))
+	return append(frame, payload...)
+}
+
+// sinks records the writers opened by an Assembler.
+type sinks map[uint64]*bytes.Buffer
+
+func (s sinks) open(id uint64, _ string, _ int64) (io.Writer, error) {
+	s[id] = new(bytes.Buffer)
+	return s[id], nil
+}
+
+func TestAssemblerFeed(t *testing.T) {
+	s := sinks{}
+	a := NewAssembler(s.open)
+
+	steps := []struct {
+		frame  []byte
+		chunk  bool
+		err    error
+		offset int64
+	}{
+		{[]byte("not a chunk"), false, nil, 0},
+		{chunk(1, 0, 0, fibril.ChunkStart, "a.txt"), true, nil, 0},
+		{chunk(1, 1, 0, 0, "hello "), true, nil, 6},
+		{chunk(1, 3, 6, 0, "lost"), true, &OffsetError{TransferID: 1, Expected: 6, Got: 6}, 6}, // Skipped sequence
+		{chunk(1, 2, 9, 0, "gap"), true, &OffsetError{TransferID: 1, Expected: 6, Got: 9}, 6},
+		{chunk(2, 1, 0, 0, "orphan"), true, ErrUnknownTransfer, 0},
+		{chunk(1, 2, 6, fibril.ChunkFinal, "world"), true, nil, 11},
+	}
+	for i, st := range steps {
+		tr, isChunk, err := a.Feed(st.frame)
+		if isChunk != st.chunk {
+			t.Fatalf("step %d: chunk = %t, want %t", i, isChunk, st.chunk)
+		}
+		var oe *OffsetError
+		if errors.As(st.err, &oe) {
+			if got, ok := err.(*OffsetError); !ok || *got != *oe {
+				t.Fatalf("step %d: error = %v, want %v", i, err, st.err)
+			}
+		} else if err != st.err {
+			t.Fatalf("step %d: error = %v, want %v", i, err, st.err)
+		}
+		if tr != nil && tr.Offset != st.offset {
+			t.Fatalf("step %d: offset = %d, want %d", i, tr.Offset, st.offset)
+		}
+	}
+
+	if got := s[1].String(); got != "hello world" {
+		t.Fatalf("received %q, want %q", got, "hello world")
+	}
+	if p := a.Pending(); len(p) != 0 {
+		t.Fatalf("Pending = %v after the final chunk, want none", p)
+	}
+}
+
+func TestAssemblerAbort(t *testing.T) {
+	a := NewAssembler(sinks{}.open)
+	a.Feed(chunk(1, 0, 0, fibril.ChunkStart, "a.txt"))
+	a.Feed(chunk(1, 1, 0, 0, "part"))
+
+	if _, _, err := a.Feed(chunk(1, 2, 4, fibril.ChunkAbort, "")); err != ErrTransferAborted {
+		t.Fatalf("abort frame error = %v, want ErrTransferAborted", err)
+	}
+	if p := a.Pending(); len(p) != 0 {
+		t.Fatalf("Pending = %v after an abort, want none", p)
+	}
+	if _, _, err := a.Feed(chunk(1, 3, 4, 0, "more")); err != ErrUnknownTransfer {
+		t.Fatalf("chunk after abort error = %v, want ErrUnknownTransfer", err)
+	}
+}
+
+func TestAssemblerResume(t *testing.T) {
+	s := sinks{}
+	a := NewAssembler(s.open)
+	a.Feed(chunk(1, 0, 0, fibril.ChunkStart, "a.txt"))
+	a.Feed(chunk(1, 1, 0, 0, "hello "))
+
+	// The connection dropped: the transfer is pending at the offset to resume from.
+	p := a.Pending()
+	if len(p) != 1 || p[0].ID != 1 || p[0].Offset != 6 || p[0].Done {
+		t.Fatalf("Pending = %+v, want transfer 1 at offset 6", p)
+	}
+
+	// A restart from a different offset is rejected, one from Offset continues.
+	if _, _, err := a.Feed(chunk(1, 0, 0, fibril.ChunkStart, "a.txt")); err == nil {
+		t.Fatal("restart from offset 0 accepted, want an OffsetError")
+	}
+	if _, _, err := a.Feed(chunk(1, 0, 6, fibril.ChunkStart, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	tr, _, err := a.Feed(chunk(1, 1, 6, fibril.ChunkFinal, "world"))
+	if err != nil || !tr.Done {
+		t.Fatalf("final chunk = %+v, %v, want the transfer done", tr, err)
+	}
+	if got := s[1].String(); got != "hello world" {
+		t.Fatalf("received %q, want %q", got, "hello world")
+	}
+}
@@ -0,0 +1,294 @@
+package fibril
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ClientLocation records which node a client is connected to.
+type ClientLocation struct {
+	UUID        string            `json:"uuid"`           // Client UUID
+	Node        string            `json:"node"`           // Node the client is connected to
+	Keys        map[string]string `json:"keys,omitempty"` // Indexed keys of the client, formatted with fmt.Sprint
+	ConnectedAt time.Time         `json:"connected_at"`   // Time the client connected
+}
+
+// ClientDirectory is the cluster-wide registry of client locations. Each node
+// registers its clients on connect and whenever an indexed key changes, and
+// unregisters them on disconnect, from a background worker. Implementations must
+// be safe for concurrent use.
+type ClientDirectory interface {
+	// Register records or updates the location of a client.
+	Register(ctx context.Context, loc ClientLocation) error
+	// Unregister removes a client.
+	Unregister(ctx context.Context, uuid string) error
+	// Locate returns the location of a client, or ErrClientNotFound.
+	Locate(ctx context.Context, uuid string) (ClientLocation, error)
+	// LocateKey returns the locations of all clients whose indexed key holds value.
+	LocateKey(ctx context.Context, key, value string) ([]ClientLocation, error)
+}
+
+// Cluster message operations.
+const (
+	ClusterSend       = "send"       // Deliver Data as a frame of Type to the listed clients
+	ClusterDisconnect = "disconnect" // Disconnect the listed clients with Reason
+	ClusterHeartbeat  = "heartbeat"  // Data holds the sender's NodeStatus as JSON
+	ClusterPublish    = "publish"    // Publish Data to Topic on the receiving node only
+	ClusterResync     = "resync"     // Register all clients of the receiving node in the directory again
+)
+
+// ClusterMessage is a command sent from one node to another.
+type ClusterMessage struct {
+	Op     string   `json:"op"`               // One of the Cluster* operations
+	From   string   `json:"from"`             // Node that sent the message
+	UUIDs  []string `json:"uuids,omitempty"`  // Target clients on the receiving node
+	Type   int      `json:"type,omitempty"`   // WebSocket message type of Data
+	Data   []byte   `json:"data,omitempty"`   // Message payload
+	Reason string   `json:"reason,omitempty"` // Close message of a disconnect
+	Topic  string   `json:"topic,omitempty"`  // Topic of a publish
+}
+
+// ClusterTransport carries messages between nodes. Implementations must be safe for
+// concurrent use.
+type ClusterTransport interface {
+	// Send delivers msg to the given node and returns the error reported by its handler.
+	Send(ctx context.Context, node string, msg ClusterMessage) error
+	// Broadcast delivers msg to every node except msg.From.
+	Broadcast(ctx context.Context, msg ClusterMessage) error
+	// Listen registers the handler for messages addressed to node.
+	Listen(node string, handler func(ClusterMessage) error)
+}
+
+// nodeRemover is implemented by directories that can drop every client of a node,
+// which the heartbeat protocol uses to purge the entries of expired nodes.
+type nodeRemover interface {
+	RemoveNode(ctx context.Context, node string) error
+}
+
+// nopDirectory is used when a cluster is configured without a directory: nodes share
+// counts and presence through heartbeats, but clients cannot be located remotely.
+type nopDirectory struct{}
+
+func (nopDirectory) Register(context.Context, ClientLocation) error { return nil }
+
+func (nopDirectory) Unregister(context.Context, string) error { return nil }
+
+func (nopDirectory) Locate(context.Context, string) (ClientLocation, error) {
+	return ClientLocation{}, ErrClientNotFound
+}
+
+func (nopDirectory) LocateKey(context.Context, string, string) ([]ClientLocation, error) {
+	return nil, nil
+}
+
+// cluster routes lookups and sends for clients connected to other nodes.
+// A nil *cluster means clustering is disabled and every method is a no-op.
+type cluster struct {
+	hub       *Hub
+	node      string           // Name of this node
+	directory ClientDirectory  // Cluster-wide client locations
+	transport ClusterTransport // Node-to-node messaging
+	timeout   time.Duration    // Timeout of directory and transport calls
+	nodes     *nodeTable       // Latest heartbeat of every live peer, nil if heartbeats are disabled
+	interval  time.Duration    // Interval between heartbeats
+	queue     *clusterQueue    // Directory writes and broadcasts waiting for queueLoop
+}
+
+// context returns a context bounded by the cluster timeout.
+func (c *cluster) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), c.timeout)
+}
+
+// location builds the directory entry of a local client.
+func (c *cluster) location(client *Client) ClientLocation {
+	loc := ClientLocation{
+		UUID:        client.GetUUID(),
+		Node:        c.node,
+		Keys:        make(map[string]string),
+		ConnectedAt: client.ConnectedAt(),
+	}
+	for k, v := range client.Keys() {
+		if c.hub.index.indexed(k) {
+			loc.Keys[fmt.Sprint(k)] = fmt.Sprint(v)
+		}
+	}
+	return loc
+}
+
+// register queues a directory write recording a local client. Directory errors
+// are reported to the error handler.
+func (c *cluster) register(client *Client) {
+	if c == nil {
+		return
+	}
+	c.queue.register(client, c.location(client))
+}
+
+// unregister queues the removal of a local client from the directory.
+func (c *cluster) unregister(client *Client) {
+	if c == nil {
+		return
+	}
+	c.queue.unregister(client)
+}
+
+// locate returns the location of a client connected to any node.
+func (c *cluster) locate(uuid string) (ClientLocation, error) {
+	if c == nil {
+		return ClientLocation{}, ErrClientNotFound
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+	loc, err := c.directory.Locate(ctx, uuid)
+	if err == nil && !c.alive(loc.Node) {
+		return ClientLocation{}, ErrClientNotFound
+	}
+	return loc, err
+}
+
+// locateKey returns the locations of the clients on any node whose indexed key holds value.
+func (c *cluster) locateKey(key any, value any) ([]ClientLocation, error) {
+	if c == nil {
+		return nil, nil
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+	locs, err := c.directory.LocateKey(ctx, fmt.Sprint(key), fmt.Sprint(value))
+	if err != nil {
+		return nil, err
+	}
+	live := locs[:0]
+	for _, loc := range locs {
+		if c.alive(loc.Node) {
+			live = append(live, loc)
+		}
+	}
+	return live, nil
+}
+
+// forward sends msg to the node hosting the client with the given UUID.
+// It returns ErrClientNotFound if the client is not connected to another node.
+func (c *cluster) forward(uuid string, msg ClusterMessage) error {
+	loc, err := c.locate(uuid)
+	if err != nil {
+		return err
+	}
+	if loc.Node == c.node {
+		// Stale entry: the client is no longer connected here.
+		return ErrClientNotFound
+	}
+
+	msg.From = c.node
+	msg.UUIDs = []string{uuid}
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.transport.Send(ctx, loc.Node, msg)
+}
+
+// forwardKey sends msg to every other node hosting clients whose indexed key holds
+// value and returns the number of remote clients targeted.
+func (c *cluster) forwardKey(key any, value any, msg ClusterMessage) (int, error) {
+	locs, err := c.locateKey(key, value)
+	if err != nil {
+		return 0, err
+	}
+
+	byNode := make(map[string][]string)
+	for _, loc := range locs {
+		if loc.Node != c.node {
+			byNode[loc.Node] = append(byNode[loc.Node], loc.UUID)
+		}
+	}
+
+	var (
+		errs []error
+		n    int
+	)
+	for node, uuids := range byNode {
+		m := msg
+		m.From = c.node
+		m.UUIDs = uuids
+		ctx, cancel := c.context()
+		err := c.transport.Send(ctx, node, m)
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("fibril: node %s: %w", node, err))
+			continue
+		}
+		n += len(uuids)
+	}
+	return n, errors.Join(errs...)
+}
+
+// handle executes a message received from another node on local clients only.
+func (c *cluster) handle(msg ClusterMessage) error {
+	switch msg.Op {
+	case ClusterHeartbeat:
+		return c.receiveHeartbeat(msg)
+	case ClusterPublish:
+		return c.hub.publish(msg.Topic, msg.Data)
+	case ClusterResync:
+		c.resync()
+		return nil
+	}
+
+	found := 0
+	for _, uuid := range msg.UUIDs {
+		client, ok := c.hub.clientMap.Get(uuid)
+		if !ok {
+			continue
+		}
+		found++
+		switch msg.Op {
+		case ClusterSend:
+			client.writeMessage(box{t: msg.Type, msg: msg.Data})
+		case ClusterDisconnect:
+			client.Disconnect(msg.Reason)
+		default:
+			return fmt.Errorf("fibril: unknown cluster operation %q", msg.Op)
+		}
+	}
+	if found == 0 {
+		return ErrClientNotFound
+	}
+	return nil
+}
+
+// newCluster creates the cluster router from the options, or nil if clustering is disabled.
+func newCluster(h *Hub, opt *option) *cluster {
+	if opt.clusterTransport == nil {
+		return nil
+	}
+	c := &cluster{
+		hub:       h,
+		node:      opt.clusterNode,
+		directory: opt.clusterDirectory,
+		transport: opt.clusterTransport,
+		timeout:   opt.clusterTimeout,
+		interval:  opt.heartbeatInterval,
+		queue:     newClusterQueue(),
+	}
+	if c.directory == nil {
+		c.directory = nopDirectory{}
+	}
+	if c.interval > 0 {
+		c.nodes = newNodeTable(opt.heartbeatTTL)
+	}
+	return c
+}
+
+// start listens for messages from other nodes and starts the heartbeats and the
+// directory writer. It is called once the hub is fully set up, as all of them may
+// reach into the hub at once.
+func (c *cluster) start() {
+	if c == nil {
+		return
+	}
+	c.transport.Listen(c.node, c.handle)
+	go c.queueLoop()
+	if c.nodes != nil {
+		go c.heartbeatLoop(c.interval)
+	}
+}
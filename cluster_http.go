@@ -0,0 +1,151 @@
+package fibril
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// HTTPTransport is a ClusterTransport that posts messages as JSON to the other
+// nodes over HTTP. Every node mounts the receiving endpoint with Register and
+// lists the base URLs of its peers, e.g. "http://10.0.0.2:3000/cluster".
+type HTTPTransport struct {
+	mu      sync.RWMutex
+	peers   map[string]string          // node -> base URL
+	handler func(ClusterMessage) error // Handler of messages addressed to this node
+	client  *http.Client               // Client used for outgoing messages
+}
+
+// NewHTTPTransport creates a transport sending to the given peers, keyed by node name.
+func NewHTTPTransport(peers map[string]string) *HTTPTransport {
+	t := &HTTPTransport{
+		peers:  make(map[string]string, len(peers)),
+		client: &http.Client{},
+	}
+	for node, url := range peers {
+		t.peers[node] = strings.TrimSuffix(url, "/")
+	}
+	return t
+}
+
+// SetPeer adds or replaces the base URL of a peer.
+func (t *HTTPTransport) SetPeer(node, url string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.peers[node] = strings.TrimSuffix(url, "/")
+}
+
+// Register mounts the endpoint receiving messages from other nodes on router as
+// POST /messages. Protect it from public access, e.g. with a middleware on router.
+func (t *HTTPTransport) Register(router fiber.Router) {
+	router.Post("/messages", func(c *fiber.Ctx) error {
+		var msg ClusterMessage
+		if err := json.Unmarshal(c.Body(), &msg); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		t.mu.RLock()
+		handler := t.handler
+		t.mu.RUnlock()
+		if handler == nil {
+			return fiber.NewError(fiber.StatusServiceUnavailable, "fibril: cluster node not listening")
+		}
+
+		if err := handler(msg); err != nil {
+			if errors.Is(err, ErrClientNotFound) {
+				return fiber.NewError(fiber.StatusNotFound, err.Error())
+			}
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+}
+
+// Send posts msg to the given node. A 404 response is reported as ErrClientNotFound.
+func (t *HTTPTransport) Send(ctx context.Context, node string, msg ClusterMessage) error {
+	t.mu.RLock()
+	url, ok := t.peers[node]
+	t.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("fibril: unknown node %q", node)
+	}
+	return t.post(ctx, url, msg)
+}
+
+// Broadcast posts msg to every peer except msg.From.
+func (t *HTTPTransport) Broadcast(ctx context.Context, msg ClusterMessage) error {
+	t.mu.RLock()
+	peers := make(map[string]string, len(t.peers))
+	for node, url := range t.peers {
+		if node != msg.From {
+			peers[node] = url
+		}
+	}
+	t.mu.RUnlock()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for node, url := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := t.post(ctx, url, msg); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("fibril: node %s: %w", node, err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Listen registers the handler for messages received by the endpoint. The node name
+// is not used: the endpoint only serves the node it is mounted on.
+func (t *HTTPTransport) Listen(_ string, handler func(ClusterMessage) error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.handler = handler
+}
+
+// post sends msg to the endpoint below url.
+func (t *HTTPTransport) post(ctx context.Context, url string, msg ClusterMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrClientNotFound
+	case resp.StatusCode >= 300:
+		text, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("fibril: cluster request failed: %s: %s", resp.Status, bytes.TrimSpace(text))
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package fibril
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// MemoryCluster is an in-process ClientDirectory and ClusterTransport. It connects
+// several Fibril instances in the same process, which is useful for tests and for
+// running multiple nodes behind one listener.
+type MemoryCluster struct {
+	mu       sync.RWMutex
+	clients  map[string]ClientLocation                 // UUID -> location
+	keys     map[string]map[string]map[string]struct{} // key -> value -> UUIDs
+	handlers map[string]func(ClusterMessage) error     // node -> message handler
+}
+
+// NewMemoryCluster creates an empty in-memory cluster.
+func NewMemoryCluster() *MemoryCluster {
+	return &MemoryCluster{
+		clients:  make(map[string]ClientLocation),
+		keys:     make(map[string]map[string]map[string]struct{}),
+		handlers: make(map[string]func(ClusterMessage) error),
+	}
+}
+
+// Register records or updates the location of a client.
+func (m *MemoryCluster) Register(_ context.Context, loc ClientLocation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.removeLocked(loc.UUID)
+	m.clients[loc.UUID] = loc
+	for k, v := range loc.Keys {
+		values, ok := m.keys[k]
+		if !ok {
+			values = make(map[string]map[string]struct{})
+			m.keys[k] = values
+		}
+		uuids, ok := values[v]
+		if !ok {
+			uuids = make(map[string]struct{})
+			values[v] = uuids
+		}
+		uuids[loc.UUID] = struct{}{}
+	}
+	return nil
+}
+
+// Unregister removes a client.
+func (m *MemoryCluster) Unregister(_ context.Context, uuid string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.removeLocked(uuid)
+	return nil
+}
+
+// removeLocked removes a client and its key entries. m.mu must be held.
+func (m *MemoryCluster) removeLocked(uuid string) {
+	loc, ok := m.clients[uuid]
+	if !ok {
+		return
+	}
+	delete(m.clients, uuid)
+	for k, v := range loc.Keys {
+		uuids := m.keys[k][v]
+		delete(uuids, uuid)
+		if len(uuids) == 0 {
+			delete(m.keys[k], v)
+		}
+		if len(m.keys[k]) == 0 {
+			delete(m.keys, k)
+		}
+	}
+}
+
+// Locate returns the location of a client, or ErrClientNotFound.
+func (m *MemoryCluster) Locate(_ context.Context, uuid string) (ClientLocation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	loc, ok := m.clients[uuid]
+	if !ok {
+		return ClientLocation{}, ErrClientNotFound
+	}
+	return loc, nil
+}
+
+// LocateKey returns the locations of all clients whose indexed key holds value.
+func (m *MemoryCluster) LocateKey(_ context.Context, key, value string) ([]ClientLocation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	uuids := m.keys[key][value]
+	locs := make([]ClientLocation, 0, len(uuids))
+	for uuid := range uuids {
+		locs = append(locs, m.clients[uuid])
+	}
+	return locs, nil
+}
+
+// Send delivers msg to the handler registered for node.
+func (m *MemoryCluster) Send(ctx context.Context, node string, msg ClusterMessage) error {
+	m.mu.RLock()
+	handler, ok := m.handlers[node]
+	m.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("fibril: unknown node %q", node)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return handler(msg)
+}
+
+// Broadcast delivers msg to the handler of every node except msg.From.
+func (m *MemoryCluster) Broadcast(ctx context.Context, msg ClusterMessage) error {
+	m.mu.RLock()
+	handlers := make(map[string]func(ClusterMessage) error, len(m.handlers))
+	for node, handler := range m.handlers {
+		if node != msg.From {
+			handlers[node] = handler
+		}
+	}
+	m.mu.RUnlock()
+
+	var errs []error
+	for node, handler := range handlers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := handler(msg); err != nil {
+			errs = append(errs, fmt.Errorf("fibril: node %s: %w", node, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RemoveNode removes every client registered by node.
+func (m *MemoryCluster) RemoveNode(_ context.Context, node string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for uuid, loc := range m.clients {
+		if loc.Node == node {
+			m.removeLocked(uuid)
+		}
+	}
+	return nil
+}
+
+// Listen registers the handler for messages addressed to node.
+func (m *MemoryCluster) Listen(node string, handler func(ClusterMessage) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.handlers[node] = handler
+}
@@ -0,0 +1,112 @@
+package fibril
+
+import "sync"
+
+// maxQueuedBroadcasts bounds the cluster broadcasts waiting to be sent. When the
+// transport falls behind, the oldest are dropped: they carry presence events and
+// publishes, which are best effort.
+const maxQueuedBroadcasts = 4096
+
+// directoryWrite is a pending directory update of a local client.
+type directoryWrite struct {
+	client *Client        // Client the update belongs to, for error reporting
+	loc    ClientLocation // Location to register
+	remove bool           // Whether the client is unregistered instead
+}
+
+// clusterQueue holds the directory writes and broadcasts of this node until the
+// cluster worker sends them, so connects, disconnects and key changes never wait
+// on the directory or the transport. Directory writes are coalesced per client:
+// only its latest location, or its removal, is written.
+type clusterQueue struct {
+	mu         sync.Mutex
+	writes     map[string]directoryWrite // Pending directory writes by client UUID
+	broadcasts []ClusterMessage          // Pending broadcasts in the order they were queued
+	wake       chan struct{}             // Signals the worker that work is pending
+}
+
+// newClusterQueue creates an empty queue.
+func newClusterQueue() *clusterQueue {
+	return &clusterQueue{
+		writes: make(map[string]directoryWrite),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// signal wakes the worker if it is idle.
+func (q *clusterQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// register queues the registration of a client's location. A client whose removal
+// is still pending stays removed.
+func (q *clusterQueue) register(client *Client, loc ClientLocation) {
+	q.mu.Lock()
+	if w, ok := q.writes[loc.UUID]; !ok || !w.remove {
+		q.writes[loc.UUID] = directoryWrite{client: client, loc: loc}
+	}
+	q.mu.Unlock()
+	q.signal()
+}
+
+// unregister queues the removal of a client.
+func (q *clusterQueue) unregister(client *Client) {
+	q.mu.Lock()
+	q.writes[client.GetUUID()] = directoryWrite{client: client, remove: true}
+	q.mu.Unlock()
+	q.signal()
+}
+
+// broadcast queues a message for every other node.
+func (q *clusterQueue) broadcast(msg ClusterMessage) {
+	q.mu.Lock()
+	if len(q.broadcasts) >= maxQueuedBroadcasts {
+		q.broadcasts = q.broadcasts[1:]
+	}
+	q.broadcasts = append(q.broadcasts, msg)
+	q.mu.Unlock()
+	q.signal()
+}
+
+// take removes and returns all pending work.
+func (q *clusterQueue) take() (map[string]directoryWrite, []ClusterMessage) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var writes map[string]directoryWrite
+	if len(q.writes) > 0 {
+		writes = q.writes
+		q.writes = make(map[string]directoryWrite)
+	}
+	broadcasts := q.broadcasts
+	q.broadcasts = nil
+	return writes, broadcasts
+}
+
+// queueLoop sends the queued directory writes and broadcasts in the background.
+func (c *cluster) queueLoop() {
+	for range c.queue.wake {
+		writes, broadcasts := c.queue.take()
+		for uuid, w := range writes {
+			ctx, cancel := c.context()
+			var err error
+			if w.remove {
+				err = c.directory.Unregister(ctx, uuid)
+			} else {
+				err = c.directory.Register(ctx, w.loc)
+			}
+			cancel()
+			if err != nil {
+				w.client.reportError(err)
+			}
+		}
+		for _, msg := range broadcasts {
+			ctx, cancel := c.context()
+			_ = c.transport.Broadcast(ctx, msg)
+			cancel()
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package fibril
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// lossyTransport is a MemoryCluster transport whose node drops incoming heartbeats
+// while drop is set, as if it were partitioned from its peers.
+type lossyTransport struct {
+	*MemoryCluster
+	drop *atomic.Bool
+}
+
+func (t lossyTransport) Listen(node string, handler func(ClusterMessage) error) {
+	t.MemoryCluster.Listen(node, func(msg ClusterMessage) error {
+		if msg.Op == ClusterHeartbeat && t.drop.Load() {
+			return nil
+		}
+		return handler(msg)
+	})
+}
+
+// locatedOn reports whether the directory places the client with uuid on node.
+func locatedOn(mc *MemoryCluster, uuid, node string) bool {
+	loc, err := mc.Locate(context.Background(), uuid)
+	return err == nil && loc.Node == node
+}
+
+func TestClusterMultiNode(t *testing.T) {
+	const topic = "presence"
+	mc := NewMemoryCluster()
+	drop := new(atomic.Bool)
+
+	n1 := New(
+		WithCluster("n1", mc, mc),
+		WithHeartbeat(20*time.Millisecond, 60*time.Millisecond),
+		WithKeyPresence("user", topic),
+	)
+	n2 := New(
+		WithCluster("n2", mc, lossyTransport{mc, drop}),
+		WithHeartbeat(20*time.Millisecond, 60*time.Millisecond),
+		WithKeyPresence("user", topic),
+	)
+
+	uuids := make(chan string, 1)
+	n1.ConnectHandler(func(c *Client) { uuids <- c.GetUUID() })
+	n2.ConnectHandler(func(c *Client) { c.SubscribeText(topic) })
+	url1, url2 := serveNode(t, n1), serveNode(t, n2)
+
+	watcher := dial(t, url2, "bob")
+	eventually(t, "bob's subscription", func() bool { return n2.SubscriberCount(topic) == 1 })
+
+	alice := dial(t, url1, "alice")
+	uuid := <-uuids
+
+	// Skip bob's own join, which n2 may publish after subscribing him.
+	var ev KeyPresenceEvent
+	for ev.Value != "alice" {
+		if err := json.Unmarshal([]byte(readText(t, watcher)), &ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ev.Type != KeyPresenceJoin || ev.Value != "alice" || ev.UUID != uuid {
+		t.Fatalf("presence event on n2 = %+v, want alice joining", ev)
+	}
+
+	eventually(t, "alice's directory entry", func() bool { return locatedOn(mc, uuid, "n1") })
+	locs, err := n2.LocateKey("user", "alice")
+	if err != nil || len(locs) != 1 || locs[0].Node != "n1" {
+		t.Fatalf("n2.LocateKey = %v, %v, want alice on n1", locs, err)
+	}
+	if err := n2.SendTextToClient(uuid, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readText(t, alice); msg != "hello" {
+		t.Fatalf("alice received %q, want %q", msg, "hello")
+	}
+
+	// Partition n1 from n2 until n2 purges it, then heal: n1 must register alice again.
+	drop.Store(true)
+	eventually(t, "n1's purge", func() bool { return !locatedOn(mc, uuid, "n1") })
+	drop.Store(false)
+	eventually(t, "alice's re-registration", func() bool { return locatedOn(mc, uuid, "n1") })
+	if err := n2.SendTextToClient(uuid, "again"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readText(t, alice); msg != "again" {
+		t.Fatalf("alice received %q, want %q", msg, "again")
+	}
+}
+
+func TestClusterPresenceDiffs(t *testing.T) {
+	const topic = "room:42"
+	mc := NewMemoryCluster()
+
+	n1 := New(WithCluster("n1", mc, mc), WithPresenceDiffs())
+	n2 := New(WithCluster("n2", mc, mc), WithPresenceDiffs())
+
+	clients := make(chan *Client, 1)
+	n1.ConnectHandler(func(c *Client) { clients <- c })
+	n2.ConnectHandler(func(c *Client) { c.SubscribeText(topic) })
+	url1, url2 := serveNode(t, n1), serveNode(t, n2)
+
+	watcher := dial(t, url2, "bob")
+	eventually(t, "bob's subscription", func() bool { return n2.SubscriberCount(topic) == 1 })
+	dial(t, url1, "alice")
+	alice := <-clients
+
+	expect := func(typ string) {
+		t.Helper()
+		var diff PresenceDiff
+		if err := json.Unmarshal([]byte(readText(t, watcher)), &diff); err != nil {
+			t.Fatal(err)
+		}
+		if diff.Type != typ || diff.UserID != "alice" {
+			t.Fatalf("diff on n2 = %+v, want %s of alice", diff, typ)
+		}
+	}
+
+	alice.SubscribeWithPresence(topic, PresenceMeta{UserID: "alice", Status: "online"})
+	expect(PresenceJoin)
+	if members := n1.Presence(topic); len(members) != 1 {
+		t.Fatalf("n1.Presence = %v, want alice", members)
+	}
+
+	alice.Unsubscribe(topic)
+	expect(PresenceLeave)
+	if members := n1.Presence(topic); len(members) != 0 {
+		t.Fatalf("n1.Presence = %v after Unsubscribe, want none", members)
+	}
+}
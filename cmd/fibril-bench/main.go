@@ -0,0 +1,309 @@
+// Command fibril-bench load-tests a fibril server. It opens N concurrent WebSocket
+// clients, runs a scenario and reports the connect rate, message latency
+// percentiles, throughput and dropped messages.
+//
+// Scenarios:
+//
+//	idle       hold the connections open for the duration
+//	echo       senders send messages that the server echoes back
+//	broadcast  senders send messages that the server broadcasts to every client
+//	pubsub     clients subscribe to -topics topics and senders publish to their topic
+//
+// With -serve, an in-process fibril server is started for self-contained benchmarks.
+// Otherwise the server at -url must implement the bench protocol described in server.go.
+package main
+
+import (
+	"flag"
+	"fmt"
+	fasthttpws "github.com/fasthttp/websocket"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// config holds the command-line settings.
+type config struct {
+	url         string
+	serve       string
+	scenario    string
+	clients     int
+	senders     int
+	concurrency int
+	rate        float64
+	size        int
+	topics      int
+	duration    time.Duration
+	wait        time.Duration
+}
+
+// benchClient is one benchmark connection and its measurements.
+type benchClient struct {
+	id       int
+	conn     *fasthttpws.Conn
+	topic    string
+	received atomic.Int64
+	samples  latencies
+	done     chan struct{}
+}
+
+// result collects the totals of a run.
+type result struct {
+	connected   int
+	failed      int
+	connectTime time.Duration
+	connects    latencies
+	sent        atomic.Int64
+	sendErrors  atomic.Int64
+	expected    int64
+	received    int64
+	sendTime    time.Duration
+	latency     latencies
+}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.url, "url", "ws://localhost:3000/ws", "WebSocket URL of the server under test")
+	flag.StringVar(&cfg.serve, "serve", "", "start an in-process server on this address (e.g. 127.0.0.1:0) and ignore -url")
+	flag.StringVar(&cfg.scenario, "scenario", "echo", "scenario to run: idle, echo, broadcast or pubsub")
+	flag.IntVar(&cfg.clients, "clients", 100, "number of concurrent clients")
+	flag.IntVar(&cfg.senders, "senders", 0, "number of clients sending messages, 0 means all")
+	flag.IntVar(&cfg.concurrency, "connect-concurrency", 50, "number of connections opened in parallel")
+	flag.Float64Var(&cfg.rate, "rate", 10, "messages per second sent by each sender")
+	flag.IntVar(&cfg.size, "size", 64, "message payload size in bytes")
+	flag.IntVar(&cfg.topics, "topics", 10, "number of topics in the pubsub scenario")
+	flag.DurationVar(&cfg.duration, "duration", 10*time.Second, "how long to send messages or hold idle connections")
+	flag.DurationVar(&cfg.wait, "wait", 2*time.Second, "how long to wait for in-flight messages after sending stops")
+	flag.Parse()
+
+	switch cfg.scenario {
+	case "idle", "echo", "broadcast", "pubsub":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown scenario %q\n", cfg.scenario)
+		os.Exit(2)
+	}
+	if cfg.clients <= 0 || cfg.rate <= 0 || cfg.topics <= 0 {
+		fmt.Fprintln(os.Stderr, "-clients, -rate and -topics must be positive")
+		os.Exit(2)
+	}
+	if cfg.senders <= 0 || cfg.senders > cfg.clients {
+		cfg.senders = cfg.clients
+	}
+
+	if cfg.serve != "" {
+		url, stop, err := serve(cfg.serve)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "serve: %v\n", err)
+			os.Exit(1)
+		}
+		defer stop()
+		cfg.url = url
+	}
+
+	r := run(cfg)
+	report(cfg, r)
+}
+
+// run connects the clients, runs the scenario and collects the results.
+func run(cfg config) *result {
+	r := &result{}
+	clients := connect(cfg, r)
+	defer func() {
+		for _, c := range clients {
+			_ = c.conn.WriteControl(fasthttpws.CloseMessage,
+				fasthttpws.FormatCloseMessage(fasthttpws.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			_ = c.conn.Close()
+			<-c.done
+			r.received += c.received.Load()
+			r.latency = append(r.latency, c.samples...)
+		}
+	}()
+
+	if len(clients) == 0 {
+		return r
+	}
+	for _, c := range clients {
+		go c.read()
+	}
+
+	if cfg.scenario == "idle" {
+		time.Sleep(cfg.duration)
+		return r
+	}
+
+	// fanout is the number of deliveries expected for a message sent by each client.
+	fanout := make([]int64, len(clients))
+	switch cfg.scenario {
+	case "echo":
+		for i := range fanout {
+			fanout[i] = 1
+		}
+	case "broadcast":
+		for i := range fanout {
+			fanout[i] = int64(len(clients))
+		}
+	case "pubsub":
+		members := make(map[string]int64)
+		for _, c := range clients {
+			c.topic = "bench-" + strconv.Itoa(c.id%cfg.topics)
+			members[c.topic]++
+			_ = c.conn.WriteMessage(fasthttpws.TextMessage, []byte("sub:"+c.topic))
+		}
+		for i, c := range clients {
+			fanout[i] = members[c.topic]
+		}
+		// Give the server time to process the subscriptions before publishing.
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	senders := clients[:min(cfg.senders, len(clients))]
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i, c := range senders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n := c.send(cfg, start.Add(cfg.duration), r)
+			atomic.AddInt64(&r.expected, n*fanout[i])
+		}()
+	}
+	wg.Wait()
+	r.sendTime = time.Since(start)
+
+	deadline := time.Now().Add(cfg.wait)
+	for time.Now().Before(deadline) && totalReceived(clients) < atomic.LoadInt64(&r.expected) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return r
+}
+
+// connect opens the clients with up to cfg.concurrency dials in flight.
+func connect(cfg config, r *result) []*benchClient {
+	var (
+		mu      sync.Mutex
+		clients []*benchClient
+		wg      sync.WaitGroup
+		next    atomic.Int64
+	)
+
+	start := time.Now()
+	for range min(cfg.concurrency, cfg.clients) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				id := int(next.Add(1)) - 1
+				if id >= cfg.clients {
+					return
+				}
+				began := time.Now()
+				conn, _, err := fasthttpws.DefaultDialer.Dial(cfg.url, nil)
+				took := time.Since(began)
+
+				mu.Lock()
+				if err != nil {
+					r.failed++
+					if r.failed == 1 {
+						fmt.Fprintf(os.Stderr, "connect: %v\n", err)
+					}
+				} else {
+					clients = append(clients, &benchClient{id: id, conn: conn, done: make(chan struct{})})
+					r.connects = append(r.connects, took)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	r.connectTime = time.Since(start)
+	r.connected = len(clients)
+	return clients
+}
+
+// send sends timestamped messages at cfg.rate until the deadline and returns the number sent.
+func (c *benchClient) send(cfg config, deadline time.Time, r *result) int64 {
+	interval := time.Duration(float64(time.Second) / cfg.rate)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var prefix string
+	switch cfg.scenario {
+	case "echo":
+		prefix = "echo:"
+	case "broadcast":
+		prefix = "bcast:"
+	case "pubsub":
+		prefix = "pub:" + c.topic + ":"
+	}
+	padding := strings.Repeat("x", max(cfg.size-20, 0))
+
+	var sent int64
+	for now := range ticker.C {
+		if now.After(deadline) {
+			break
+		}
+		msg := prefix + strconv.FormatInt(time.Now().UnixNano(), 10) + ":" + padding
+		if err := c.conn.WriteMessage(fasthttpws.TextMessage, []byte(msg)); err != nil {
+			r.sendErrors.Add(1)
+			break
+		}
+		sent++
+		r.sent.Add(1)
+	}
+	return sent
+}
+
+// read receives messages until the connection is closed, recording the latency
+// from the timestamp embedded by the sender.
+func (c *benchClient) read() {
+	defer close(c.done)
+	for {
+		_, msg, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		stamp, _, _ := strings.Cut(string(msg), ":")
+		if ns, err := strconv.ParseInt(stamp, 10, 64); err == nil {
+			c.samples = append(c.samples, now.Sub(time.Unix(0, ns)))
+		}
+		c.received.Add(1)
+	}
+}
+
+// totalReceived returns the number of messages received by all clients.
+func totalReceived(clients []*benchClient) int64 {
+	var n int64
+	for _, c := range clients {
+		n += c.received.Load()
+	}
+	return n
+}
+
+// report prints the results of a run. The clients have been closed, so their
+// samples can be read without synchronization.
+func report(cfg config, r *result) {
+	fmt.Printf("scenario:    %s against %s\n", cfg.scenario, cfg.url)
+	fmt.Printf("connections: %d connected, %d failed in %v (%.0f/s)\n",
+		r.connected, r.failed, round(r.connectTime), perSecond(int64(r.connected), r.connectTime))
+	fmt.Printf("connect:     %s\n", r.connects)
+
+	if cfg.scenario == "idle" {
+		return
+	}
+
+	fmt.Printf("sent:        %d messages in %v (%.0f/s), %d errors\n",
+		r.sent.Load(), round(r.sendTime), perSecond(r.sent.Load(), r.sendTime), r.sendErrors.Load())
+	fmt.Printf("received:    %d of %d expected (%.0f/s)\n",
+		r.received, r.expected, perSecond(r.received, r.sendTime))
+	if drops := r.expected - r.received; drops > 0 {
+		fmt.Printf("dropped:     %d (%.2f%%)\n", drops, 100*float64(drops)/float64(r.expected))
+	} else {
+		fmt.Printf("dropped:     0\n")
+	}
+	fmt.Printf("latency:     %s\n", r.latency)
+}
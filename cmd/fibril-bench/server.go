@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/lishank0119/fibril"
+	"net"
+	"strings"
+)
+
+// serve starts an in-process fibril server implementing the bench protocol on addr
+// and returns its WebSocket URL and a function that shuts it down.
+//
+// The bench protocol is a handful of text commands, which an external server must
+// implement the same way to be benchmarked:
+//
+//	echo:<payload>          reply <payload> to the sender
+//	bcast:<payload>         broadcast <payload> to every client
+//	sub:<topic>             subscribe the sender to <topic>
+//	pub:<topic>:<payload>   publish <payload> to <topic>
+func serve(addr string) (string, func(), error) {
+	f := fibril.New(
+		fibril.WithMaxMessageSize(1<<20),
+		fibril.WithMessageBufferSize(4096),
+	)
+	f.TextMessageHandler(func(client *fibril.Client, msg string) {
+		cmd, arg, _ := strings.Cut(msg, ":")
+		switch cmd {
+		case "echo":
+			_ = client.SendText(arg)
+		case "bcast":
+			f.BroadcastText(arg)
+		case "sub":
+			client.SubscribeText(arg)
+		case "pub":
+			topic, payload, _ := strings.Cut(arg, ":")
+			_ = f.Publish(topic, []byte(payload))
+		}
+	})
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/ws", f.Handler())
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", nil, err
+	}
+	go func() {
+		_ = app.Listener(ln)
+	}()
+
+	return "ws://" + ln.Addr().String() + "/ws", func() {
+		f.DisconnectAll("bench finished")
+		_ = app.Shutdown()
+	}, nil
+}
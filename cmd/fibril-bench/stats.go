@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// latencies summarizes a set of latency samples.
+type latencies []time.Duration
+
+// percentile returns the p-th percentile (0-100) of the sorted samples.
+func (l latencies) percentile(p float64) time.Duration {
+	if len(l) == 0 {
+		return 0
+	}
+	i := int(float64(len(l)-1) * p / 100)
+	return l[i]
+}
+
+// String formats the percentiles of the samples, sorting them in place.
+func (l latencies) String() string {
+	if len(l) == 0 {
+		return "n/a"
+	}
+	slices.Sort(l)
+	return fmt.Sprintf("p50=%v p90=%v p99=%v max=%v",
+		round(l.percentile(50)), round(l.percentile(90)), round(l.percentile(99)), round(l[len(l)-1]))
+}
+
+// round trims durations to a readable precision.
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
+
+// perSecond returns n per second over d.
+func perSecond(n int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) / d.Seconds()
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/lishank0119/fibril"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// render formats a received frame for display. JSON is indented, fibril chunk
+// frames show their header, MessagePack documents are shown as JSON and other
+// binary payloads are hex dumped.
+func render(t int, msg []byte, pretty bool) string {
+	if t == fasthttpws.TextMessage {
+		if pretty && json.Valid(msg) {
+			var buf bytes.Buffer
+			if json.Indent(&buf, msg, "  ", "  ") == nil {
+				return buf.String()
+			}
+		}
+		return string(msg)
+	}
+
+	if fibril.IsChunk(msg) {
+		if h, payload, err := fibril.ParseChunk(msg); err == nil {
+			return fmt.Sprintf("[chunk id=%d seq=%d offset=%d flags=%s] %d bytes", h.TransferID, h.Sequence, h.Offset, chunkFlags(h.Flags), len(payload))
+		}
+	}
+
+	if v, err := decodeMsgpack(msg); err == nil {
+		if out, err := json.MarshalIndent(v, "  ", "  "); err == nil {
+			return "[msgpack] " + string(out)
+		}
+	}
+
+	if utf8.Valid(msg) && !bytes.ContainsFunc(msg, func(r rune) bool { return r < 0x20 && r != '\n' && r != '\t' }) {
+		return fmt.Sprintf("[binary %d bytes] %s", len(msg), msg)
+	}
+	return fmt.Sprintf("[binary %d bytes]\n%s", len(msg), strings.TrimRight(hex.Dump(msg), "\n"))
+}
+
+// chunkFlags names the flags set on a chunk frame.
+func chunkFlags(flags byte) string {
+	var names []string
+	if flags&fibril.ChunkStart != 0 {
+		names = append(names, "start")
+	}
+	if flags&fibril.ChunkFinal != 0 {
+		names = append(names, "final")
+	}
+	if flags&fibril.ChunkAbort != 0 {
+		names = append(names, "abort")
+	}
+	if len(names) == 0 {
+		return "-"
+	}
+	return strings.Join(names, "|")
+}
+
+// errMsgpack reports data that is not a complete MessagePack document.
+var errMsgpack = errors.New("not msgpack")
+
+// decodeMsgpack decodes data as a single MessagePack map or array. Anything else,
+// including trailing bytes, is rejected so that arbitrary binary is not misread.
+func decodeMsgpack(data []byte) (any, error) {
+	if len(data) == 0 {
+		return nil, errMsgpack
+	}
+	if b := data[0]; !(b>>4 == 0x8 || b>>4 == 0x9 || b == 0xdc || b == 0xdd || b == 0xde || b == 0xdf) {
+		return nil, errMsgpack
+	}
+	d := &msgpackDecoder{data: data}
+	v, err := d.value(0)
+	if err != nil || d.pos != len(data) {
+		return nil, errMsgpack
+	}
+	return v, nil
+}
+
+// msgpackDecoder is a minimal MessagePack decoder used for display only.
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+// next returns the next n bytes.
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, errMsgpack
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// uint reads a big-endian unsigned integer of n bytes.
+func (d *msgpackDecoder) uint(n int) (uint64, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// value decodes the next value.
+func (d *msgpackDecoder) value(depth int) (any, error) {
+	if depth > 64 {
+		return nil, errMsgpack
+	}
+	tag, err := d.uint(1)
+	if err != nil {
+		return nil, err
+	}
+	b := byte(tag)
+
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b>>4 == 0x8:
+		return d.mapN(int(b&0x0f), depth)
+	case b>>4 == 0x9:
+		return d.arrayN(int(b&0x0f), depth)
+	case b>>5 == 0x5:
+		return d.str(int(b & 0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uint(1 << (b - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		raw, err := d.next(int(n))
+		return hex.EncodeToString(raw), err
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.uint(1 << (b - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.ext(int(n))
+	case 0xca:
+		v, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := d.uint(8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return d.uint(1 << (b - 0xcc))
+	case 0xd0:
+		v, err := d.uint(1)
+		return int64(int8(v)), err
+	case 0xd1:
+		v, err := d.uint(2)
+		return int64(int16(v)), err
+	case 0xd2:
+		v, err := d.uint(4)
+		return int64(int32(v)), err
+	case 0xd3:
+		v, err := d.uint(8)
+		return int64(v), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.ext(1 << (b - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uint(1 << (b - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.str(int(n))
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (b - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayN(int(n), depth)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (b - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapN(int(n), depth)
+	}
+	return nil, errMsgpack
+}
+
+// str reads a UTF-8 string of n bytes.
+func (d *msgpackDecoder) str(n int) (any, error) {
+	b, err := d.next(n)
+	if err != nil || !utf8.Valid(b) {
+		return nil, errMsgpack
+	}
+	return string(b), nil
+}
+
+// ext reads an extension value with n bytes of data.
+func (d *msgpackDecoder) ext(n int) (any, error) {
+	t, err := d.uint(1)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	if int8(t) == -1 && (n == 4 || n == 8) {
+		// Timestamp extension: seconds, or nanoseconds and seconds packed into 64 bits.
+		if n == 4 {
+			return map[string]any{"timestamp": binary.BigEndian.Uint32(raw)}, nil
+		}
+		v := binary.BigEndian.Uint64(raw)
+		return map[string]any{"timestamp": v & 0x3ffffffff, "nanos": v >> 34}, nil
+	}
+	return map[string]any{"ext": int8(t), "data": hex.EncodeToString(raw)}, nil
+}
+
+// arrayN reads an array of n values.
+func (d *msgpackDecoder) arrayN(n, depth int) (any, error) {
+	if n > len(d.data)-d.pos {
+		return nil, errMsgpack
+	}
+	arr := make([]any, 0, n)
+	for range n {
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+	}
+	return arr, nil
+}
+
+// mapN reads a map of n key-value pairs. Keys are formatted as strings for display.
+func (d *msgpackDecoder) mapN(n, depth int) (any, error) {
+	if n > len(d.data)-d.pos {
+		return nil, errMsgpack
+	}
+	m := make(map[string]any, n)
+	for range n {
+		k, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		m[fmt.Sprint(k)] = v
+	}
+	return m, nil
+}
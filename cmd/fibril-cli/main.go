@@ -0,0 +1,133 @@
+// Command fibril-cli is an interactive WebSocket client for fibril servers.
+//
+// Lines typed on stdin (or read from a -script file) are sent as text frames, or
+// run as commands when they start with a slash; see /help. Received frames are
+// pretty-printed: JSON is indented, MessagePack documents are decoded, fibril
+// chunk frames show their header and other binary payloads are hex dumped.
+//
+// fibril does not mandate a wire envelope, so topic subscriptions are sent using
+// the -sub and -unsub templates, and RPC calls use a JSON-RPC style envelope whose
+// reply is matched on its "id" field.
+//
+// Usage:
+//
+//	fibril-cli [flags] ws://host/path
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	fasthttpws "github.com/fasthttp/websocket"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// headerFlags collects repeated -H flags.
+type headerFlags []string
+
+func (h *headerFlags) String() string { return strings.Join(*h, ", ") }
+
+func (h *headerFlags) Set(v string) error {
+	if !strings.Contains(v, ":") {
+		return fmt.Errorf("header %q must be in the form \"Name: value\"", v)
+	}
+	*h = append(*h, v)
+	return nil
+}
+
+func main() {
+	var headers headerFlags
+	flag.Var(&headers, "H", "request header \"Name: value\" (repeatable)")
+	subprotocols := flag.String("subprotocol", "", "comma-separated subprotocols to offer")
+	script := flag.String("script", "", "run commands from this file instead of stdin and exit")
+	subTemplate := flag.String("sub", `{"type":"subscribe","topic":%s}`, "message template sent by /sub, %s is the topic as a JSON string")
+	unsubTemplate := flag.String("unsub", `{"type":"unsubscribe","topic":%s}`, "message template sent by /unsub, %s is the topic as a JSON string")
+	timeout := flag.Duration("timeout", 5*time.Second, "default timeout of /rpc and /expect")
+	pretty := flag.Bool("pretty", true, "pretty-print JSON frames")
+	quiet := flag.Bool("quiet", false, "do not print received frames")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: fibril-cli [flags] ws://host/path\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	header := http.Header{}
+	for _, h := range headers {
+		name, value, _ := strings.Cut(h, ":")
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	dialer := *fasthttpws.DefaultDialer
+	if *subprotocols != "" {
+		for _, p := range strings.Split(*subprotocols, ",") {
+			dialer.Subprotocols = append(dialer.Subprotocols, strings.TrimSpace(p))
+		}
+	}
+
+	conn, resp, err := dialer.Dial(flag.Arg(0), header)
+	if err != nil {
+		if resp != nil {
+			err = fmt.Errorf("%w (HTTP %s)", err, resp.Status)
+		}
+		fmt.Fprintf(os.Stderr, "connect: %v\n", err)
+		os.Exit(1)
+	}
+
+	s := newSession(conn, *pretty, *quiet, *timeout)
+	s.subTemplate = *subTemplate
+	s.unsubTemplate = *unsubTemplate
+	s.printf("* connected to %s", flag.Arg(0))
+	if p := conn.Subprotocol(); p != "" {
+		s.printf("* subprotocol %s", p)
+	}
+	go s.read()
+
+	var in io.Reader = os.Stdin
+	if *script != "" {
+		f, err := os.Open(*script)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "script: %v\n", err)
+			os.Exit(2)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	code := 0
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if *script != "" {
+			if strings.TrimSpace(text) == "" || strings.HasPrefix(strings.TrimSpace(text), "#") {
+				continue
+			}
+			s.printf("> %s", text)
+		}
+
+		quit, err := s.exec(text)
+		if err != nil {
+			if *script != "" {
+				fmt.Fprintf(os.Stderr, "%s:%d: %v\n", *script, line, err)
+				code = 1
+				break
+			}
+			s.printf("! %v", err)
+		}
+		if quit {
+			break
+		}
+	}
+
+	s.close(fasthttpws.CloseNormalClosure, "")
+	os.Exit(code)
+}
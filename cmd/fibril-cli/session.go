@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	fasthttpws "github.com/fasthttp/websocket"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// frame is a received data frame.
+type frame struct {
+	t   int
+	msg []byte
+}
+
+// maxBacklog is the number of received frames kept for /expect and /rpc. Older frames
+// are dropped, so an interactive session that never waits does not grow without bound.
+const maxBacklog = 1000
+
+// session is an open connection and the frames received on it. Received frames
+// are kept in a backlog until /expect or /rpc consumes them, so scripts can wait
+// for replies that arrived before the wait started.
+type session struct {
+	conn    *fasthttpws.Conn
+	pretty  bool
+	quiet   bool
+	timeout time.Duration
+
+	subTemplate   string
+	unsubTemplate string
+
+	writeMu sync.Mutex // Serializes the data, ping and close frames written by commands
+	outMu   sync.Mutex // Serializes output lines
+
+	mu       sync.Mutex
+	backlog  []frame
+	notify   chan struct{} // Closed and replaced whenever a frame arrives or the connection ends
+	closed   error         // Read error that ended the connection
+	nextID   int64
+	pingSent map[string]time.Time
+}
+
+// helpText lists the available commands.
+const helpText = `commands:
+  <text>                    send a text frame
+  /text <text>              send a text frame
+  /json <json>              validate and send JSON as a text frame
+  /binary <text>            send the bytes of <text> as a binary frame
+  /hex <hex>                send hex-encoded bytes as a binary frame
+  /sub <topic>              subscribe to a topic (see -sub)
+  /unsub <topic>            unsubscribe from a topic (see -unsub)
+  /rpc <method> [params]    call a method with JSON params and wait for the reply
+  /ping                     send a ping and show the round-trip time
+  /expect <regexp> [dur]    wait for a received frame matching <regexp>
+  /sleep <dur>              pause, e.g. /sleep 500ms
+  /close [code] [reason]    close the connection and exit
+  /quit                     exit
+  /help                     show this help`
+
+// newSession wraps an open connection.
+func newSession(conn *fasthttpws.Conn, pretty, quiet bool, timeout time.Duration) *session {
+	s := &session{
+		conn:     conn,
+		pretty:   pretty,
+		quiet:    quiet,
+		timeout:  timeout,
+		notify:   make(chan struct{}),
+		pingSent: make(map[string]time.Time),
+	}
+	conn.SetPongHandler(func(data string) error {
+		s.mu.Lock()
+		sent, ok := s.pingSent[data]
+		delete(s.pingSent, data)
+		s.mu.Unlock()
+		if ok {
+			s.printf("* pong rtt=%v", time.Since(sent).Round(time.Microsecond))
+		}
+		return nil
+	})
+	return s
+}
+
+// printf writes an output line.
+func (s *session) printf(format string, args ...any) {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	fmt.Printf(format+"\n", args...)
+}
+
+// read receives frames until the connection ends.
+func (s *session) read() {
+	for {
+		t, msg, err := s.conn.ReadMessage()
+		if err != nil {
+			var ce *fasthttpws.CloseError
+			if errors.As(err, &ce) {
+				s.printf("* closed by server: %d %s", ce.Code, ce.Text)
+			} else {
+				s.printf("* connection closed: %v", err)
+			}
+			s.mu.Lock()
+			s.closed = err
+			close(s.notify)
+			s.mu.Unlock()
+			return
+		}
+
+		if !s.quiet {
+			s.printf("< %s", render(t, msg, s.pretty))
+		}
+		s.push(frame{t: t, msg: msg})
+	}
+}
+
+// push adds a received frame to the backlog, dropping the oldest frames beyond
+// maxBacklog, and wakes up waiters.
+func (s *session) push(f frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.backlog = append(s.backlog, f)
+	if n := len(s.backlog) - maxBacklog; n > 0 {
+		clear(s.backlog[:n])
+		s.backlog = s.backlog[n:]
+	}
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// wait blocks until a frame matching fn is received or the timeout expires.
+// The matching frame and all frames before it are removed from the backlog.
+func (s *session) wait(fn func(frame) bool, timeout time.Duration) (frame, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		s.mu.Lock()
+		for i, f := range s.backlog {
+			if fn(f) {
+				s.backlog = s.backlog[i+1:]
+				s.mu.Unlock()
+				return f, nil
+			}
+		}
+		if s.closed != nil {
+			s.mu.Unlock()
+			return frame{}, fmt.Errorf("connection closed: %w", s.closed)
+		}
+		notify := s.notify
+		s.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-deadline.C:
+			return frame{}, fmt.Errorf("timed out after %v", timeout)
+		}
+	}
+}
+
+// write sends a data frame.
+func (s *session) write(t int, msg []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.conn.WriteMessage(t, msg)
+}
+
+// close sends a close frame and closes the connection.
+func (s *session) close(code int, reason string) {
+	s.writeMu.Lock()
+	_ = s.conn.WriteControl(fasthttpws.CloseMessage, fasthttpws.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	s.writeMu.Unlock()
+	_ = s.conn.Close()
+}
+
+// exec runs one input line and reports whether the session should end.
+func (s *session) exec(line string) (bool, error) {
+	if !strings.HasPrefix(line, "/") {
+		return false, s.write(fasthttpws.TextMessage, []byte(line))
+	}
+
+	cmd, arg, _ := strings.Cut(line[1:], " ")
+	arg = strings.TrimSpace(arg)
+
+	switch cmd {
+	case "text":
+		return false, s.write(fasthttpws.TextMessage, []byte(arg))
+
+	case "json":
+		if !json.Valid([]byte(arg)) {
+			return false, errors.New("invalid JSON")
+		}
+		return false, s.write(fasthttpws.TextMessage, []byte(arg))
+
+	case "binary":
+		return false, s.write(fasthttpws.BinaryMessage, []byte(arg))
+
+	case "hex":
+		data, err := hex.DecodeString(strings.Join(strings.Fields(arg), ""))
+		if err != nil {
+			return false, err
+		}
+		return false, s.write(fasthttpws.BinaryMessage, data)
+
+	case "sub", "unsub":
+		if arg == "" {
+			return false, fmt.Errorf("usage: /%s <topic>", cmd)
+		}
+		template := s.subTemplate
+		if cmd == "unsub" {
+			template = s.unsubTemplate
+		}
+		return false, s.write(fasthttpws.TextMessage, topicMessage(template, arg))
+
+	case "rpc":
+		return false, s.rpc(arg)
+
+	case "ping":
+		s.mu.Lock()
+		s.nextID++
+		payload := strconv.FormatInt(s.nextID, 10)
+		s.pingSent[payload] = time.Now()
+		s.mu.Unlock()
+
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+		return false, s.conn.WriteControl(fasthttpws.PingMessage, []byte(payload), time.Now().Add(s.timeout))
+
+	case "expect":
+		return false, s.expect(arg)
+
+	case "sleep":
+		d, err := time.ParseDuration(arg)
+		if err != nil {
+			return false, err
+		}
+		time.Sleep(d)
+		return false, nil
+
+	case "close":
+		code := fasthttpws.CloseNormalClosure
+		codeArg, reason, _ := strings.Cut(arg, " ")
+		if codeArg != "" {
+			n, err := strconv.Atoi(codeArg)
+			if err != nil {
+				return false, fmt.Errorf("invalid close code %q", codeArg)
+			}
+			code = n
+		}
+		s.close(code, reason)
+		return true, nil
+
+	case "quit", "exit":
+		return true, nil
+
+	case "help":
+		s.printf("%s", helpText)
+		return false, nil
+	}
+
+	return false, fmt.Errorf("unknown command /%s, see /help", cmd)
+}
+
+// topicMessage fills a -sub or -unsub template with the topic encoded as a JSON string.
+func topicMessage(template, topic string) []byte {
+	quoted, _ := json.Marshal(topic)
+	return []byte(fmt.Sprintf(template, quoted))
+}
+
+// rpc sends a JSON-RPC style request and waits for the reply with the same id.
+func (s *session) rpc(arg string) error {
+	method, params, _ := strings.Cut(arg, " ")
+	if method == "" {
+		return errors.New("usage: /rpc <method> [params]")
+	}
+	if params == "" {
+		params = "null"
+	}
+	if !json.Valid([]byte(params)) {
+		return errors.New("params must be valid JSON")
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	id := s.nextID
+	s.mu.Unlock()
+
+	req, _ := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"method":  method,
+		"params":  json.RawMessage(params),
+	})
+	start := time.Now()
+	if err := s.write(fasthttpws.TextMessage, req); err != nil {
+		return err
+	}
+
+	_, err := s.wait(func(f frame) bool {
+		var reply struct {
+			ID json.Number `json:"id"`
+		}
+		if f.t != fasthttpws.TextMessage || json.Unmarshal(f.msg, &reply) != nil {
+			return false
+		}
+		return reply.ID.String() == strconv.FormatInt(id, 10)
+	}, s.timeout)
+	if err != nil {
+		return fmt.Errorf("rpc %s: %w", method, err)
+	}
+	s.printf("* rpc %s replied in %v", method, time.Since(start).Round(time.Microsecond))
+	return nil
+}
+
+// expect waits for a received frame whose rendered form matches a regular expression.
+func (s *session) expect(arg string) error {
+	timeout := s.timeout
+	pattern := arg
+	if i := strings.LastIndexByte(arg, ' '); i > 0 {
+		if d, err := time.ParseDuration(arg[i+1:]); err == nil {
+			pattern, timeout = strings.TrimSpace(arg[:i]), d
+		}
+	}
+	if pattern == "" {
+		return errors.New("usage: /expect <regexp> [timeout]")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.wait(func(f frame) bool {
+		return re.Match(f.msg) || re.MatchString(render(f.t, f.msg, false))
+	}, timeout)
+	if err != nil {
+		return fmt.Errorf("expect %s: %w", pattern, err)
+	}
+	return nil
+}
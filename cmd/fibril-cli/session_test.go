@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	fasthttpws "github.com/fasthttp/websocket"
+)
+
+func TestTopicMessage(t *testing.T) {
+	for _, topic := range []string{"news", `say "hi"`, "tab\there", "\x01控制", "</script>"} {
+		msg := topicMessage(`{"type":"subscribe","topic":%s}`, topic)
+		var got struct {
+			Topic string `json:"topic"`
+		}
+		if err := json.Unmarshal(msg, &got); err != nil || got.Topic != topic {
+			t.Errorf("topicMessage(%q) = %s (%v), want valid JSON with the topic", topic, msg, err)
+		}
+	}
+}
+
+func TestBacklogCap(t *testing.T) {
+	s := &session{notify: make(chan struct{})}
+	for i := 0; i < maxBacklog+10; i++ {
+		s.push(frame{t: fasthttpws.TextMessage, msg: []byte(strconv.Itoa(i))})
+	}
+	if n := len(s.backlog); n != maxBacklog {
+		t.Fatalf("backlog holds %d frames, want %d", n, maxBacklog)
+	}
+
+	// The oldest frames were dropped: the first one left is frame 10.
+	f, err := s.wait(func(frame) bool { return true }, time.Second)
+	if err != nil || string(f.msg) != "10" {
+		t.Fatalf("first frame = %q, %v, want 10", f.msg, err)
+	}
+	if _, err := s.wait(func(f frame) bool { return string(f.msg) == "5" }, 10*time.Millisecond); err == nil {
+		t.Fatal("a dropped frame was still waiting in the backlog")
+	}
+}
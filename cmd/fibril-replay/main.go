@@ -0,0 +1,300 @@
+// Command fibril-replay replays connections recorded with fibril.WithRecorder
+// against a running fibril server and diffs the server's responses against the
+// frames in the recording.
+//
+// Usage:
+//
+//	fibril-replay [flags] recording.jsonl...
+//
+// Each recording is replayed on its own connection, one after another. Inbound
+// frames are sent with their original timing scaled by -speed, or as fast as
+// possible with -speed 0. The exit status is 1 if any response differs.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/lishank0119/fibril"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// skipHeaders are request headers that are set by the dialer and must not be replayed.
+var skipHeaders = map[string]bool{
+	"Host":                     true,
+	"Connection":               true,
+	"Upgrade":                  true,
+	"Content-Length":           true,
+	"Sec-Websocket-Key":        true,
+	"Sec-Websocket-Version":    true,
+	"Sec-Websocket-Extensions": true,
+	"Sec-Websocket-Protocol":   true,
+}
+
+// frame is a data frame sent or received during a replay.
+type frame struct {
+	t   int
+	msg []byte
+}
+
+func main() {
+	target := flag.String("url", "ws://localhost:3000/ws", "WebSocket URL of the server to replay against")
+	speed := flag.Float64("speed", 1, "timing multiplier: 1 preserves the recorded timing, 2 replays twice as fast, 0 replays at max speed")
+	wait := flag.Duration("wait", 2*time.Second, "how long to wait for outstanding responses after the last frame is sent")
+	headers := flag.Bool("headers", true, "replay the recorded request headers")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: fibril-replay [flags] recording.jsonl...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		records, err := load(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			os.Exit(2)
+		}
+
+		diffs, err := replay(records, *target, *speed, *wait, *headers)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			failed = true
+			continue
+		}
+		if len(diffs) == 0 {
+			fmt.Printf("ok   %s\n", path)
+			continue
+		}
+		failed = true
+		fmt.Printf("FAIL %s\n", path)
+		for _, d := range diffs {
+			fmt.Println(d)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// load reads all records of a recording file.
+func load(path string) ([]fibril.Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []fibril.Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var r fibril.Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("empty recording")
+	}
+	return records, nil
+}
+
+// requestHeader returns the recorded request headers to send with the upgrade request,
+// leaving out redacted values. The Cookie header is rebuilt from the recorded cookies
+// that were not redacted, since the recorded header is usually redacted as a whole.
+func requestHeader(hs *fibril.RecordedRequest) http.Header {
+	header := http.Header{}
+	for k, v := range hs.Headers {
+		if !skipHeaders[http.CanonicalHeaderKey(k)] && v != fibril.RecordRedacted {
+			header.Set(k, v)
+		}
+	}
+
+	names := make([]string, 0, len(hs.Cookies))
+	for name, v := range hs.Cookies {
+		if v != fibril.RecordRedacted {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return header
+	}
+	sort.Strings(names)
+	cookies := make([]string, 0, len(names))
+	for _, name := range names {
+		cookies = append(cookies, (&http.Cookie{Name: name, Value: hs.Cookies[name]}).String())
+	}
+	header.Set("Cookie", strings.Join(cookies, "; "))
+	return header
+}
+
+// replay connects to the server, sends the recorded inbound frames and returns the
+// differences between the recorded outbound frames and the frames received.
+func replay(records []fibril.Record, target string, speed float64, wait time.Duration, replayHeaders bool) ([]string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+	dialer := *fasthttpws.DefaultDialer
+	if hs := records[0].Handshake; records[0].Kind == fibril.RecordHandshake && hs != nil {
+		q := u.Query()
+		for k, v := range hs.Query {
+			if !q.Has(k) && v != fibril.RecordRedacted {
+				q.Set(k, v)
+			}
+		}
+		u.RawQuery = q.Encode()
+
+		if replayHeaders {
+			header = requestHeader(hs)
+		}
+		if hs.Subprotocol != "" {
+			dialer.Subprotocols = []string{hs.Subprotocol}
+		}
+	}
+
+	conn, _, err := dialer.Dial(u.String(), header)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+
+	var expected []frame
+	for _, r := range records {
+		if r.Kind == fibril.RecordOutbound && r.Type != fasthttpws.CloseMessage {
+			expected = append(expected, recordFrame(r))
+		}
+	}
+
+	received := make(chan frame, 64)
+	go func() {
+		defer close(received)
+		for {
+			t, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- frame{t: t, msg: msg}
+		}
+	}()
+
+	var actual []frame
+	collect := func() {
+		for {
+			select {
+			case f, ok := <-received:
+				if !ok {
+					return
+				}
+				actual = append(actual, f)
+			default:
+				return
+			}
+		}
+	}
+
+	base := records[0].Time
+	start := time.Now()
+	for _, r := range records {
+		if r.Kind != fibril.RecordInbound {
+			continue
+		}
+		if speed > 0 {
+			at := start.Add(time.Duration(float64(r.Time.Sub(base)) / speed))
+			time.Sleep(time.Until(at))
+		}
+		f := recordFrame(r)
+		if err := conn.WriteMessage(f.t, f.msg); err != nil {
+			return nil, fmt.Errorf("write: %w", err)
+		}
+		collect()
+	}
+
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+wait:
+	for len(actual) < len(expected) {
+		select {
+		case f, ok := <-received:
+			if !ok {
+				break wait
+			}
+			actual = append(actual, f)
+		case <-deadline.C:
+			break wait
+		}
+	}
+	collect()
+
+	_ = conn.WriteControl(fasthttpws.CloseMessage,
+		fasthttpws.FormatCloseMessage(fasthttpws.CloseNormalClosure, ""), time.Now().Add(time.Second))
+
+	return diff(expected, actual), nil
+}
+
+// recordFrame returns the frame stored in a record.
+func recordFrame(r fibril.Record) frame {
+	if r.Type == fasthttpws.TextMessage {
+		return frame{t: r.Type, msg: []byte(r.Text)}
+	}
+	return frame{t: r.Type, msg: r.Data}
+}
+
+// diff compares the recorded and received frames position by position.
+func diff(expected, actual []frame) []string {
+	var diffs []string
+	for i := 0; i < max(len(expected), len(actual)); i++ {
+		switch {
+		case i >= len(actual):
+			diffs = append(diffs, fmt.Sprintf("  #%d missing:  %s", i, describe(expected[i])))
+		case i >= len(expected):
+			diffs = append(diffs, fmt.Sprintf("  #%d unexpected: %s", i, describe(actual[i])))
+		case expected[i].t != actual[i].t || !bytes.Equal(expected[i].msg, actual[i].msg):
+			diffs = append(diffs,
+				fmt.Sprintf("  #%d - %s", i, describe(expected[i])),
+				fmt.Sprintf("  #%d + %s", i, describe(actual[i])))
+		}
+	}
+	return diffs
+}
+
+// describe formats a frame for the diff output.
+func describe(f frame) string {
+	if f.t == fasthttpws.TextMessage {
+		return fmt.Sprintf("text %q", truncate(string(f.msg)))
+	}
+	return fmt.Sprintf("binary %d bytes %x", len(f.msg), []byte(truncate(string(f.msg))))
+}
+
+// truncate shortens long payloads in the diff output.
+func truncate(s string) string {
+	const limit = 120
+	if len(s) <= limit {
+		return s
+	}
+	return s[:limit] + "..."
+}
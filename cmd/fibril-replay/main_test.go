@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/lishank0119/fibril"
+)
+
+func TestRequestHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		hs     fibril.RecordedRequest
+		cookie string
+	}{
+		{
+			name: "cookies",
+			hs: fibril.RecordedRequest{
+				Headers: map[string]string{"Cookie": fibril.RecordRedacted},
+				Cookies: map[string]string{"theme": "dark", "session": fibril.RecordRedacted, "lang": "en"},
+			},
+			cookie: "lang=en; theme=dark",
+		},
+		{
+			name: "all-redacted",
+			hs: fibril.RecordedRequest{
+				Headers: map[string]string{"Cookie": fibril.RecordRedacted},
+				Cookies: map[string]string{"session": fibril.RecordRedacted},
+			},
+			cookie: "",
+		},
+		{
+			name:   "allowed-header",
+			hs:     fibril.RecordedRequest{Headers: map[string]string{"Cookie": "a=1"}},
+			cookie: "a=1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.hs.Headers["User-Agent"] = "test"
+			tt.hs.Headers["Authorization"] = fibril.RecordRedacted
+			tt.hs.Headers["Sec-WebSocket-Key"] = "dGhlIHNhbXBsZSBub25jZQ=="
+
+			header := requestHeader(&tt.hs)
+			if got := header.Get("Cookie"); got != tt.cookie {
+				t.Fatalf("Cookie = %q, want %q", got, tt.cookie)
+			}
+			want := http.Header{"User-Agent": {"test"}}
+			if tt.cookie != "" {
+				want.Set("Cookie", tt.cookie)
+			}
+			if len(header) != len(want) || header.Get("User-Agent") != "test" {
+				t.Fatalf("header = %v, want %v", header, want)
+			}
+		})
+	}
+}
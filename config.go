@@ -0,0 +1,274 @@
+package fibril
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration that is read from and written to configuration
+// files as a string such as "10s" or "1m30s".
+type Duration time.Duration
+
+// UnmarshalText parses a duration string.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText formats the duration as a string.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Config is the declarative form of the server tunables, loadable from YAML, JSON
+// or environment variables. Handlers, protocols and key policies are code and are
+// still set through OptFuncs, which can be passed to NewFromConfig alongside it.
+type Config struct {
+	ShardCount           int      `json:"shard_count" yaml:"shard_count" env:"SHARD_COUNT"`                                  // Number of shards for load distribution
+	MaxMessageSize       int64    `json:"max_message_size" yaml:"max_message_size" env:"MAX_MESSAGE_SIZE"`                   // Maximum size of a message (in bytes)
+	MessageBufferSize    int      `json:"message_buffer_size" yaml:"message_buffer_size" env:"MESSAGE_BUFFER_SIZE"`          // Buffer size for message channels
+	WriteWait            Duration `json:"write_wait" yaml:"write_wait" env:"WRITE_WAIT"`                                     // Maximum duration to wait for a write operation to complete
+	PongWait             Duration `json:"pong_wait" yaml:"pong_wait" env:"PONG_WAIT"`                                        // Duration to wait for a pong response
+	PingPeriod           Duration `json:"ping_period" yaml:"ping_period" env:"PING_PERIOD"`                                  // Interval for sending ping messages, must be less than PongWait
+	DisconnectDelayClose Duration `json:"disconnect_delay_close" yaml:"disconnect_delay_close" env:"DISCONNECT_DELAY_CLOSE"` // Delay before closing a disconnected client
+	MaxConnections       int      `json:"max_connections" yaml:"max_connections" env:"MAX_CONNECTIONS"`                      // Maximum number of concurrent connections, 0 means unlimited
+	MaxConnectionsPerIP  int      `json:"max_connections_per_ip" yaml:"max_connections_per_ip" env:"MAX_CONNECTIONS_PER_IP"` // Maximum number of concurrent connections per IP, 0 means unlimited
+	AcceptRate           float64  `json:"accept_rate" yaml:"accept_rate" env:"ACCEPT_RATE"`                                  // Accepted connections per second, 0 means unlimited
+	AcceptBurst          int      `json:"accept_burst" yaml:"accept_burst" env:"ACCEPT_BURST"`                               // Connections accepted in a burst above AcceptRate
+	WriteBatchSize       int      `json:"write_batch_size" yaml:"write_batch_size" env:"WRITE_BATCH_SIZE"`                   // Maximum number of queued messages written per wakeup
+	MaxStreamSize        int64    `json:"max_stream_size" yaml:"max_stream_size" env:"MAX_STREAM_SIZE"`                      // Maximum size of a streamed binary message (in bytes)
+	StreamTimeout        Duration `json:"stream_timeout" yaml:"stream_timeout" env:"STREAM_TIMEOUT"`                         // Maximum duration to receive a streamed message, 0 means no extra limit
+	TrustedProxies       []string `json:"trusted_proxies" yaml:"trusted_proxies" env:"TRUSTED_PROXIES"`                      // Proxies (IPs or CIDRs) allowed to report the client IP
+	LatencyLimit         Duration `json:"latency_limit" yaml:"latency_limit" env:"LATENCY_LIMIT"`                            // Average round-trip time above which clients are disconnected, 0 disables the limit
+	LatencySustain       Duration `json:"latency_sustain" yaml:"latency_sustain" env:"LATENCY_SUSTAIN"`                      // How long the average must stay above LatencyLimit
+	IdleTimeout          Duration `json:"idle_timeout" yaml:"idle_timeout" env:"IDLE_TIMEOUT"`                               // Time without application messages before the idle handler runs, 0 disables it
+	MaxLifetime          Duration `json:"max_lifetime" yaml:"max_lifetime" env:"MAX_LIFETIME"`                               // Maximum duration of a connection, 0 means unlimited
+	LifetimeJitter       Duration `json:"lifetime_jitter" yaml:"lifetime_jitter" env:"LIFETIME_JITTER"`                      // Random extra lifetime of up to this duration per connection
+}
+
+// DefaultConfig returns a Config holding the default settings used by New.
+func DefaultConfig() Config {
+	return configFromOption(defaultOption())
+}
+
+// configFromOption returns the settings held by o.
+func configFromOption(o *option) Config {
+	proxies := make([]string, 0, len(o.trustedProxies))
+	for _, p := range o.trustedProxies {
+		proxies = append(proxies, p.String())
+	}
+	return Config{
+		ShardCount:           o.shardCount,
+		MaxMessageSize:       o.maxMessageSize,
+		MessageBufferSize:    o.messageBufferSize,
+		WriteWait:            Duration(o.writeWait),
+		PongWait:             Duration(o.pongWait),
+		PingPeriod:           Duration(o.pingPeriod),
+		DisconnectDelayClose: Duration(o.disconnectDelayClose),
+		MaxConnections:       o.maxConnections,
+		MaxConnectionsPerIP:  o.maxConnectionsPerIP,
+		AcceptRate:           o.acceptRate,
+		AcceptBurst:          max(o.acceptBurst, 1),
+		WriteBatchSize:       o.writeBatchSize,
+		MaxStreamSize:        o.maxStreamSize,
+		StreamTimeout:        Duration(o.streamTimeout),
+		TrustedProxies:       proxies,
+		LatencyLimit:         Duration(o.latencyLimit),
+		LatencySustain:       Duration(o.latencySustain),
+		IdleTimeout:          Duration(o.idleTimeout),
+		MaxLifetime:          Duration(o.maxLifetime),
+		LifetimeJitter:       Duration(o.lifetimeJitter),
+	}
+}
+
+// LoadConfig reads a Config from a YAML (.yaml, .yml) or JSON (.json) file.
+// Settings missing from the file keep their default values, and unknown settings,
+// such as misspelled names, are rejected.
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	err := cfg.Load(path)
+	return cfg, err
+}
+
+// Load reads settings from a YAML (.yaml, .yml) or JSON (.json) file into c.
+// Settings missing from the file keep their current values, and unknown settings
+// are rejected.
+func (c *Config) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err = dec.Decode(c); errors.Is(err, io.EOF) {
+			err = nil // Empty file
+		}
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	default:
+		return fmt.Errorf("fibril: unsupported config file extension %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("fibril: parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadEnv overrides settings from environment variables named prefix + "_" + the
+// setting name, e.g. FIBRIL_PONG_WAIT=30s or FIBRIL_TRUSTED_PROXIES=10.0.0.0/8,127.0.0.1.
+// Unset variables leave the current values untouched.
+func (c *Config) LoadEnv(prefix string) error {
+	if prefix != "" && !strings.HasSuffix(prefix, "_") {
+		prefix += "_"
+	}
+
+	var errs []error
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name := prefix + field.Tag.Get("env")
+		raw, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setField(v.Field(i), strings.TrimSpace(raw)); err != nil {
+			errs = append(errs, fmt.Errorf("fibril: %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// setField parses raw into a Config field.
+func setField(f reflect.Value, raw string) error {
+	switch f.Interface().(type) {
+	case Duration:
+		var d Duration
+		if err := d.UnmarshalText([]byte(raw)); err != nil {
+			return err
+		}
+		f.Set(reflect.ValueOf(d))
+	case []string:
+		var list []string
+		for _, s := range strings.Split(raw, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+		f.Set(reflect.ValueOf(list))
+	case int, int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported field type %s", f.Type())
+	}
+	return nil
+}
+
+// Validate checks the settings for invalid values and inconsistent combinations
+// and reports all problems found as a single joined error.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("fibril: "+format, args...))
+		}
+	}
+
+	check(c.ShardCount >= 2, "shard_count must be at least 2, got %d", c.ShardCount)
+	check(c.MaxMessageSize >= 256, "max_message_size must be at least 256, got %d", c.MaxMessageSize)
+	check(c.MessageBufferSize > 0, "message_buffer_size must be positive, got %d", c.MessageBufferSize)
+	check(c.WriteWait > 0, "write_wait must be positive, got %v", time.Duration(c.WriteWait))
+	check(c.PongWait > 0, "pong_wait must be positive, got %v", time.Duration(c.PongWait))
+	check(c.PingPeriod > 0, "ping_period must be positive, got %v", time.Duration(c.PingPeriod))
+	check(c.PingPeriod < c.PongWait, "ping_period (%v) must be less than pong_wait (%v)",
+		time.Duration(c.PingPeriod), time.Duration(c.PongWait))
+	check(c.DisconnectDelayClose >= 0, "disconnect_delay_close must not be negative, got %v", time.Duration(c.DisconnectDelayClose))
+	check(c.MaxConnections >= 0, "max_connections must not be negative, got %d", c.MaxConnections)
+	check(c.MaxConnectionsPerIP >= 0, "max_connections_per_ip must not be negative, got %d", c.MaxConnectionsPerIP)
+	check(c.MaxConnections == 0 || c.MaxConnectionsPerIP <= c.MaxConnections,
+		"max_connections_per_ip (%d) must not exceed max_connections (%d)", c.MaxConnectionsPerIP, c.MaxConnections)
+	check(c.AcceptRate >= 0, "accept_rate must not be negative, got %v", c.AcceptRate)
+	check(c.AcceptRate == 0 || c.AcceptBurst >= 1, "accept_burst must be at least 1 when accept_rate is set, got %d", c.AcceptBurst)
+	check(c.WriteBatchSize >= 1, "write_batch_size must be at least 1, got %d", c.WriteBatchSize)
+	check(c.WriteBatchSize <= c.MessageBufferSize || c.MessageBufferSize <= 0,
+		"write_batch_size (%d) must not exceed message_buffer_size (%d)", c.WriteBatchSize, c.MessageBufferSize)
+	check(c.MaxStreamSize >= c.MaxMessageSize, "max_stream_size (%d) must not be less than max_message_size (%d)",
+		c.MaxStreamSize, c.MaxMessageSize)
+	check(c.StreamTimeout >= 0, "stream_timeout must not be negative, got %v", time.Duration(c.StreamTimeout))
+	check(c.LatencyLimit >= 0, "latency_limit must not be negative, got %v", time.Duration(c.LatencyLimit))
+	check(c.LatencySustain >= 0, "latency_sustain must not be negative, got %v", time.Duration(c.LatencySustain))
+	check(c.IdleTimeout >= 0, "idle_timeout must not be negative, got %v", time.Duration(c.IdleTimeout))
+	check(c.MaxLifetime >= 0, "max_lifetime must not be negative, got %v", time.Duration(c.MaxLifetime))
+	check(c.LifetimeJitter >= 0, "lifetime_jitter must not be negative, got %v", time.Duration(c.LifetimeJitter))
+	for _, p := range c.TrustedProxies {
+		_, addrErr := netip.ParseAddr(p)
+		_, prefixErr := netip.ParsePrefix(p)
+		check(addrErr == nil || prefixErr == nil, "trusted_proxies: %q is not an IP address or CIDR range", p)
+	}
+
+	return errors.Join(errs...)
+}
+
+// options converts the settings into OptFuncs.
+func (c Config) options() []OptFunc {
+	return []OptFunc{
+		WithShardCount(c.ShardCount),
+		WithMaxMessageSize(c.MaxMessageSize),
+		WithMessageBufferSize(c.MessageBufferSize),
+		WithWriteWait(time.Duration(c.WriteWait)),
+		WithPongWait(time.Duration(c.PongWait)),
+		WithPingPeriod(time.Duration(c.PingPeriod)),
+		func(o *option) {
+			o.disconnectDelayClose = time.Duration(c.DisconnectDelayClose)
+		},
+		WithMaxConnections(c.MaxConnections),
+		WithMaxConnectionsPerIP(c.MaxConnectionsPerIP),
+		WithAcceptRate(c.AcceptRate, c.AcceptBurst),
+		WithWriteBatching(c.WriteBatchSize),
+		WithMaxStreamSize(c.MaxStreamSize),
+		WithStreamTimeout(time.Duration(c.StreamTimeout)),
+		WithTrustedProxies(c.TrustedProxies...),
+		WithLatencyLimit(time.Duration(c.LatencyLimit), time.Duration(c.LatencySustain)),
+		WithIdleTimeout(time.Duration(c.IdleTimeout)),
+		WithMaxConnectionLifetime(time.Duration(c.MaxLifetime), time.Duration(c.LifetimeJitter)),
+	}
+}
+
+// NewFromConfig validates cfg and creates a Fibril instance from it. Additional
+// OptFuncs are applied after the configuration, e.g. for handlers and key policies,
+// and the combined settings are validated again.
+func NewFromConfig(cfg Config, args ...OptFunc) (*Fibril, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return newFibril(append(cfg.options(), args...))
+}
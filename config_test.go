@@ -0,0 +1,106 @@
+package fibril
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a configuration file named name into a temporary directory.
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		err     string
+	}{
+		{"yaml", "fibril.yaml", "pong_wait: 30s\nping_period: 20s\ntrusted_proxies: [10.0.0.0/8]\n", ""},
+		{"json", "fibril.json", `{"pong_wait":"30s","ping_period":"20s","trusted_proxies":["10.0.0.0/8"]}`, ""},
+		{"yaml-unknown", "fibril.yml", "pong_wait: 30s\npong_wiat: 1s\n", "pong_wiat"},
+		{"json-unknown", "fibril.json", `{"pong_wait":"30s","pong_wiat":"1s"}`, "pong_wiat"},
+		{"bad-duration", "fibril.yaml", "pong_wait: soon\n", "soon"},
+		{"extension", "fibril.toml", "pong_wait = \"30s\"\n", "unsupported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadConfig(writeConfig(t, tt.file, tt.content))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("LoadConfig error = %v, want one mentioning %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := DefaultConfig()
+			want.PongWait = Duration(30 * time.Second)
+			want.PingPeriod = Duration(20 * time.Second)
+			if cfg.PongWait != want.PongWait || cfg.PingPeriod != want.PingPeriod ||
+				len(cfg.TrustedProxies) != 1 || cfg.TrustedProxies[0] != "10.0.0.0/8" {
+				t.Fatalf("LoadConfig = %+v", cfg)
+			}
+			// Settings missing from the file keep their defaults.
+			if cfg.ShardCount != want.ShardCount || cfg.MessageBufferSize != want.MessageBufferSize {
+				t.Fatalf("unset settings = %d, %d, want the defaults", cfg.ShardCount, cfg.MessageBufferSize)
+			}
+		})
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	t.Setenv("APP_PONG_WAIT", "30s")
+	t.Setenv("APP_MAX_CONNECTIONS", " 100 ")
+	t.Setenv("APP_ACCEPT_RATE", "2.5")
+	t.Setenv("APP_TRUSTED_PROXIES", "10.0.0.0/8, 127.0.0.1,")
+
+	cfg := DefaultConfig()
+	if err := cfg.LoadEnv("APP"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PongWait != Duration(30*time.Second) || cfg.MaxConnections != 100 || cfg.AcceptRate != 2.5 ||
+		strings.Join(cfg.TrustedProxies, ",") != "10.0.0.0/8,127.0.0.1" {
+		t.Fatalf("LoadEnv = %+v", cfg)
+	}
+	if cfg.ShardCount != DefaultConfig().ShardCount {
+		t.Fatal("LoadEnv changed a setting without a variable")
+	}
+
+	t.Setenv("APP_MAX_CONNECTIONS", "many")
+	if err := cfg.LoadEnv("APP_"); err == nil || !strings.Contains(err.Error(), "APP_MAX_CONNECTIONS") {
+		t.Fatalf("LoadEnv error = %v, want one naming APP_MAX_CONNECTIONS", err)
+	}
+}
+
+func TestConstructorsValidate(t *testing.T) {
+	invalid := []OptFunc{WithPongWait(time.Second), WithPingPeriod(time.Second)}
+
+	if _, err := NewFromConfig(DefaultConfig(), invalid...); err == nil {
+		t.Fatal("NewFromConfig accepted options with ping_period equal to pong_wait")
+	}
+	cfg := DefaultConfig()
+	cfg.MessageBufferSize = 0
+	if _, err := NewFromConfig(cfg); err == nil {
+		t.Fatal("NewFromConfig accepted a zero message_buffer_size")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("New did not panic on options with ping_period equal to pong_wait")
+		}
+	}()
+	New(invalid...)
+}
@@ -7,4 +7,38 @@ var (
 	ErrWriteClosed       = errors.New("tried to write to closed a session")
 	ErrMessageBufferFull = errors.New("message buffer is full")
 	ErrClientNotFound    = errors.New("client not found")
+	ErrServerDisconnect  = errors.New("disconnected by server")
+	ErrKeyNotIndexed     = errors.New("key is not indexed")
+	ErrSessionReplaced   = errors.New("session replaced by a newer connection")
+	ErrSessionRejected   = errors.New("session limit reached")
+	ErrHighLatency       = errors.New("round-trip time above the latency limit")
+	ErrHeartbeatTimeout  = errors.New("heartbeat not answered in time")
+	ErrIdleTimeout       = errors.New("no messages exchanged within the idle timeout")
+	ErrMaxLifetime       = errors.New("connection reached its maximum lifetime")
+
+	ErrTooManyConnections       = errors.New("too many connections")
+	ErrTooManyConnectionsPerIP  = errors.New("too many connections from this address")
+	ErrTooManyConnectionsPerKey = errors.New("too many connections for this key")
+	ErrRateLimited              = errors.New("connection rate limit exceeded")
 )
+
+// DisconnectError describes a disconnect initiated by the server.
+// It is used as the cause of a client's context so handlers can tell
+// why a client went away via errors.Is on the wrapped sentinel.
+type DisconnectError struct {
+	Err    error  // Sentinel describing the kind of disconnect (e.g. ErrServerDisconnect)
+	Reason string // Close message sent to the client
+}
+
+// Error implements the error interface.
+func (e *DisconnectError) Error() string {
+	if e.Reason == "" {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + ": " + e.Reason
+}
+
+// Unwrap returns the underlying sentinel error.
+func (e *DisconnectError) Unwrap() error {
+	return e.Err
+}
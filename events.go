@@ -0,0 +1,160 @@
+package fibril
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType identifies a lifecycle event.
+type EventType int
+
+const (
+	EventConnected      EventType = iota // A client connected
+	EventDisconnected                    // A client disconnected; Err holds the cause
+	EventSubscribed                      // A client subscribed to Topic
+	EventUnsubscribed                    // A client was unsubscribed from Topic
+	EventMessageDropped                  // A message to a client was dropped; Err holds the reason
+	EventError                           // An error occurred on a client; Err holds the error
+)
+
+// String returns a human-readable name for the event type.
+func (t EventType) String() string {
+	switch t {
+	case EventConnected:
+		return "connected"
+	case EventDisconnected:
+		return "disconnected"
+	case EventSubscribed:
+		return "subscribed"
+	case EventUnsubscribed:
+		return "unsubscribed"
+	case EventMessageDropped:
+		return "message_dropped"
+	case EventError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a lifecycle event delivered to Events subscribers.
+type Event struct {
+	Type   EventType // Kind of event
+	Client *Client   // Client the event relates to
+	Topic  string    // Topic for subscribe and unsubscribe events
+	Err    error     // Disconnect cause, drop reason or error
+	Time   time.Time // Time the event occurred
+}
+
+// eventSubscriber is a single registered listener.
+type eventSubscriber struct {
+	id uint64
+	fn func(Event)
+}
+
+// Events fans lifecycle events out to any number of subscribers. Subscribers can be
+// added and removed at any time; emitting never takes a lock.
+type Events struct {
+	mu     sync.Mutex
+	nextID uint64
+	subs   atomic.Pointer[[]eventSubscriber] // Copy-on-write list of subscribers
+}
+
+// Subscribe registers fn to be called for every event and returns a function that
+// removes it. fn runs synchronously on the goroutine raising the event, so it must
+// not block; use Channel for slow consumers.
+func (e *Events) Subscribe(fn func(Event)) (unsubscribe func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.nextID++
+	id := e.nextID
+
+	var subs []eventSubscriber
+	if cur := e.subs.Load(); cur != nil {
+		subs = append(subs, *cur...)
+	}
+	subs = append(subs, eventSubscriber{id: id, fn: fn})
+	e.subs.Store(&subs)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			e.remove(id)
+		})
+	}
+}
+
+// Channel returns a channel receiving every event, buffered to size, and a function
+// that unsubscribes and closes it. Events are dropped when the channel is full so a
+// slow reader never blocks the hub.
+func (e *Events) Channel(size int) (<-chan Event, func()) {
+	ch := make(chan Event, size)
+	var mu sync.RWMutex
+	closed := false
+
+	unsubscribe := e.Subscribe(func(ev Event) {
+		mu.RLock()
+		defer mu.RUnlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- ev:
+		default:
+		}
+	})
+
+	return ch, func() {
+		unsubscribe()
+		mu.Lock()
+		defer mu.Unlock()
+		if !closed {
+			closed = true
+			close(ch)
+		}
+	}
+}
+
+// remove deletes the subscriber with the given id.
+func (e *Events) remove(id uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	cur := e.subs.Load()
+	if cur == nil {
+		return
+	}
+	subs := make([]eventSubscriber, 0, len(*cur))
+	for _, s := range *cur {
+		if s.id != id {
+			subs = append(subs, s)
+		}
+	}
+	e.subs.Store(&subs)
+}
+
+// reportError passes an error to the error handler and emits an EventError.
+func (c *Client) reportError(err error) {
+	c.opt().errorHandler(c, err)
+	c.hub.events.emit(Event{Type: EventError, Client: c, Err: err})
+}
+
+// drop reports a message that could not be queued for the client.
+func (c *Client) drop(reason error) {
+	c.opt().errorHandler(c, reason)
+	c.hub.events.emit(Event{Type: EventMessageDropped, Client: c, Err: reason})
+}
+
+// emit delivers an event to all current subscribers.
+func (e *Events) emit(ev Event) {
+	subs := e.subs.Load()
+	if subs == nil || len(*subs) == 0 {
+		return
+	}
+	ev.Time = time.Now()
+	for _, s := range *subs {
+		s.fn(ev)
+	}
+}
@@ -0,0 +1,83 @@
+package fibril
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// nextEvent receives the next event from events, failing the test after a second.
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for an event")
+		return Event{}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	f := New()
+	f.ConnectHandler(func(c *Client) { c.SubscribeText("news") })
+	events, stop := f.Events().Channel(16)
+	dial(t, serveNode(t, f), "alice")
+
+	want := []struct {
+		typ   EventType
+		topic string
+	}{
+		{EventSubscribed, "news"},
+		{EventConnected, ""},
+	}
+	var client *Client
+	for _, w := range want {
+		ev := nextEvent(t, events)
+		if ev.Type != w.typ || ev.Topic != w.topic || ev.Client == nil || ev.Time.IsZero() {
+			t.Fatalf("got %s %q, want %s %q", ev.Type, ev.Topic, w.typ, w.topic)
+		}
+		client = ev.Client
+	}
+
+	client.Disconnect("bye")
+	if ev := nextEvent(t, events); ev.Type != EventUnsubscribed || ev.Topic != "news" {
+		t.Fatalf("got %s %q, want unsubscribed news", ev.Type, ev.Topic)
+	}
+	ev := nextEvent(t, events)
+	if ev.Type != EventDisconnected || ev.Client != client {
+		t.Fatalf("got %s, want disconnected for the same client", ev.Type)
+	}
+	if !errors.Is(ev.Err, ErrServerDisconnect) {
+		t.Fatalf("disconnect cause = %v, want %v", ev.Err, ErrServerDisconnect)
+	}
+
+	stop()
+	if _, ok := <-events; ok {
+		t.Fatal("channel still open after stop")
+	}
+}
+
+func TestEventsUnsubscribe(t *testing.T) {
+	f := New()
+	var calls atomic.Int32
+	unsubscribe := f.Events().Subscribe(func(Event) { calls.Add(1) })
+	url := serveNode(t, f)
+
+	dial(t, url, "alice")
+	eventually(t, "connected event", func() bool { return calls.Load() == 1 })
+
+	unsubscribe()
+	unsubscribe() // Calling it again is a no-op.
+	events, stop := f.Events().Channel(1)
+	defer stop()
+	dial(t, url, "bob")
+	if ev := nextEvent(t, events); ev.Type != EventConnected {
+		t.Fatalf("got %s, want connected", ev.Type)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("subscriber called %d times, want 1", n)
+	}
+}
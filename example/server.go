@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
@@ -25,6 +26,8 @@ func main() {
 	f := fibril.New(
 		fibril.WithShardCount(20),       // Set the number of shards
 		fibril.WithMaxMessageSize(1024), // Set the maximum message size in bytes
+		// Disconnect older clients with the same "id"
+		fibril.WithSessionPolicy("id", fibril.SessionKickOldest, 1, "Duplicate ID detected"),
 	)
 
 	// Start a goroutine to periodically publish server time
@@ -53,14 +56,6 @@ func main() {
 			}
 		}
 
-		// Disconnect clients with the same "id"
-		f.DisconnectClientFilter("Duplicate ID detected", func(c *fibril.Client) bool {
-			if fID, ok := c.GetKey("id"); ok {
-				return c.GetUUID() != client.GetUUID() && id == fID
-			}
-			return false
-		})
-
 		// Subscribe the client to the "server-time" topic and send server time on update
 		client.Subscribe("server-time", func(msg []byte) {
 			err := client.SendText(string(msg))
@@ -79,6 +74,10 @@ func main() {
 
 	// Handle client disconnections
 	f.DisconnectHandler(func(client *fibril.Client) {
+		if errors.Is(client.Cause(), fibril.ErrSessionReplaced) {
+			log.Println("Client replaced by a newer connection, UUID:", client.GetUUID())
+			return
+		}
 		log.Println("Client disconnected, UUID:", client.GetUUID())
 	})
 
@@ -2,13 +2,16 @@ package fibril
 
 import (
 	"context"
+	"errors"
 	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"slices"
 )
 
 // Fibril represents the core WebSocket server, managing clients and message broadcasting.
 type Fibril struct {
-	option *option // Configuration options for the Fibril instance
-	hub    *Hub    // Central hub responsible for managing clients and messages
+	hub *Hub // Central hub responsible for managing clients and messages
 }
 
 // SubscriberCount returns the number of subscribers currently subscribed to a given topic.
@@ -21,7 +24,8 @@ func (f *Fibril) ListTopics() []string {
 	return f.hub.listTopics()
 }
 
-// GetClient proxies the Hub's getClient to retrieve a connected client by UUID.
+// GetClient proxies the Hub's getClient to retrieve a client connected to this node by UUID.
+// Use LocateClient to find a client on any node of a cluster.
 func (f *Fibril) GetClient(uuid string) (*Client, bool) {
 	return f.hub.getClient(uuid)
 }
@@ -41,84 +45,278 @@ func (f *Fibril) ClientLen() int {
 	return f.hub.clientMap.Len()
 }
 
+// LatencyStats returns aggregate round-trip time statistics of the clients connected
+// to this node that have answered at least one keep-alive ping.
+func (f *Fibril) LatencyStats() LatencyStats {
+	return f.hub.latencyStats()
+}
+
+// ClusterClientLen returns the number of clients connected to all live nodes, as
+// reported by their latest heartbeats. Without WithHeartbeat it equals ClientLen.
+func (f *Fibril) ClusterClientLen() int {
+	return f.hub.clusterClientLen()
+}
+
+// ClusterSubscriberCount returns the number of subscribers of a topic on all live nodes.
+// Without WithHeartbeat it equals SubscriberCount.
+func (f *Fibril) ClusterSubscriberCount(topic string) int {
+	return f.hub.clusterSubscriberCount(topic)
+}
+
+// Nodes returns the status of this node followed by every live peer.
+func (f *Fibril) Nodes() []NodeStatus {
+	return f.hub.nodes()
+}
+
+// Online returns the values of a key tracked with WithKeyPresence that are held by
+// at least one client on any live node, e.g. the IDs of all online users.
+func (f *Fibril) Online(key any) []string {
+	return f.hub.online(key)
+}
+
+// IsOnline reports whether a client holding the given key value is connected to any
+// live node. The key must be tracked with WithKeyPresence.
+func (f *Fibril) IsOnline(key any, value any) bool {
+	_, found := slices.BinarySearch(f.hub.online(key), formatKey(value))
+	return found
+}
+
+// Presence returns the users present in a topic through Client.SetPresence, one entry
+// per user however many connections it has, sorted by user ID.
+func (f *Fibril) Presence(topic string) []PresenceEntry {
+	return f.hub.topicPresence.list(topic)
+}
+
 // Publish sends a message to all subscribers of the specified topic.
 func (f *Fibril) Publish(topic string, msg []byte) error {
 	return f.hub.publish(topic, msg)
 }
 
 // SendTextToClient sends a text message to a specific client identified by UUID.
+// When clustered, clients connected to other nodes are reached through the cluster.
 func (f *Fibril) SendTextToClient(uuid string, msg string) error {
 	return f.hub.sendTextToClient(uuid, msg)
 }
 
 // SendBinaryToClient sends a binary message to a specific client identified by UUID.
+// When clustered, clients connected to other nodes are reached through the cluster.
 func (f *Fibril) SendBinaryToClient(uuid string, msg []byte) error {
 	return f.hub.sendBinaryToClient(uuid, msg)
 }
 
+// SendTextToClientContext sends a text message to a specific client identified by UUID,
+// blocking until it is queued (or written, with WithWaitWritten) or ctx is done.
+func (f *Fibril) SendTextToClientContext(ctx context.Context, uuid string, msg string, opts ...SendOption) error {
+	return f.hub.sendToClientContext(ctx, uuid, box{t: websocket.TextMessage, msg: []byte(msg)}, opts)
+}
+
+// SendBinaryToClientContext sends a binary message to a specific client identified by UUID,
+// blocking until it is queued (or written, with WithWaitWritten) or ctx is done.
+func (f *Fibril) SendBinaryToClientContext(ctx context.Context, uuid string, msg []byte, opts ...SendOption) error {
+	return f.hub.sendToClientContext(ctx, uuid, box{t: websocket.BinaryMessage, msg: msg}, opts)
+}
+
+// LocateClient returns the location of a client connected to this or, when clustered
+// with WithCluster, any other node. It returns ErrClientNotFound if no node has it.
+func (f *Fibril) LocateClient(uuid string) (ClientLocation, error) {
+	return f.hub.locateClient(uuid)
+}
+
+// LocateKey returns the locations of the clients on any node whose indexed key holds
+// the given value, e.g. every session of a user across the cluster.
+func (f *Fibril) LocateKey(key any, value any) ([]ClientLocation, error) {
+	return f.hub.locateKey(key, value)
+}
+
+// ClientsByKey returns the clients whose indexed key currently holds the given value.
+// It returns nil if the key was not declared with WithIndexedKey.
+func (f *Fibril) ClientsByKey(key any, value any) []*Client {
+	clients, _ := f.hub.clientsByKey(key, value)
+	return clients
+}
+
+// SendTextToKey sends a text message to every client whose indexed key holds the given value.
+func (f *Fibril) SendTextToKey(key any, value any, msg string) error {
+	return f.hub.sendTextToKey(key, value, msg)
+}
+
+// SendBinaryToKey sends a binary message to every client whose indexed key holds the given value.
+func (f *Fibril) SendBinaryToKey(key any, value any, msg []byte) error {
+	return f.hub.sendBinaryToKey(key, value, msg)
+}
+
+// BroadcastToKey broadcasts a text message through the hub to every client whose
+// indexed key holds the given value.
+func (f *Fibril) BroadcastToKey(key any, value any, msg string) error {
+	return f.hub.broadcastToKey(key, value, websocket.TextMessage, []byte(msg))
+}
+
+// BroadcastBinaryToKey broadcasts a binary message through the hub to every client whose
+// indexed key holds the given value.
+func (f *Fibril) BroadcastBinaryToKey(key any, value any, msg []byte) error {
+	return f.hub.broadcastToKey(key, value, websocket.BinaryMessage, msg)
+}
+
 // BroadcastText broadcasts a text message to all connected clients.
-func (f *Fibril) BroadcastText(msg string) {
-	f.hub.broadcastText(msg, nil)
+func (f *Fibril) BroadcastText(msg string, opts ...BroadcastOption) {
+	f.hub.broadcastText(msg, nil, opts)
 }
 
 // BroadcastTextFilter broadcasts a text message to clients that meet the specified filter condition.
-func (f *Fibril) BroadcastTextFilter(msg string, fn func(*Client) bool) {
-	f.hub.broadcastText(msg, fn)
+func (f *Fibril) BroadcastTextFilter(msg string, fn func(*Client) bool, opts ...BroadcastOption) {
+	f.hub.broadcastText(msg, fn, opts)
 }
 
 // BroadcastBinary broadcasts a binary message to all connected clients.
-func (f *Fibril) BroadcastBinary(msg []byte) {
-	f.hub.broadcastBinary(msg, nil)
+func (f *Fibril) BroadcastBinary(msg []byte, opts ...BroadcastOption) {
+	f.hub.broadcastBinary(msg, nil, opts)
 }
 
 // BroadcastBinaryFilter broadcasts a binary message to clients that meet the specified filter condition.
-func (f *Fibril) BroadcastBinaryFilter(msg []byte, fn func(*Client) bool) {
-	f.hub.broadcastBinary(msg, fn)
+func (f *Fibril) BroadcastBinaryFilter(msg []byte, fn func(*Client) bool, opts ...BroadcastOption) {
+	f.hub.broadcastBinary(msg, fn, opts)
 }
 
 // RegisterClient registers a new WebSocket client without additional metadata.
 func (f *Fibril) RegisterClient(conn *websocket.Conn) {
-	newClient(f.hub, conn, f.option, nil)
+	newClient(f.hub, conn, f.hub.options(), nil)
 }
 
 // RegisterClientWithKeys registers a new WebSocket client with custom key-value pairs.
 func (f *Fibril) RegisterClientWithKeys(conn *websocket.Conn, keys map[any]any) {
-	newClient(f.hub, conn, f.option, keys)
+	newClient(f.hub, conn, f.hub.options(), keys)
+}
+
+// Handler returns a Fiber handler that upgrades the request to a WebSocket and registers
+// the client. Unlike RegisterClient, the full upgrade request (headers, query, cookies,
+// route params and locals) is captured into the client's Handshake, connection limits
+// are enforced before the upgrade with HTTP 503 or 429 responses, and subprotocols
+// registered with WithProtocol are offered for negotiation.
+func (f *Fibril) Handler(config ...websocket.Config) fiber.Handler {
+	return f.HandlerWithKeys(nil, config...)
+}
+
+// HandlerWithKeys is like Handler, but registers the client with the keys returned by
+// extract, e.g. a user ID taken from an authenticated request. Per-key limits and
+// reject-newest session policies are checked before the upgrade and refused with HTTP
+// 429 or 409 responses; they are enforced again atomically when the client registers.
+func (f *Fibril) HandlerWithKeys(extract func(*fiber.Ctx) map[any]any, config ...websocket.Config) fiber.Handler {
+	upgrade := websocket.New(func(conn *websocket.Conn) {
+		keys, _ := conn.Locals(keysLocalsKey).(map[any]any)
+		f.RegisterClientWithKeys(conn, keys)
+	}, f.hub.options().withSubprotocols(config)...)
+
+	return func(c *fiber.Ctx) error {
+		if !websocket.IsWebSocketUpgrade(c) {
+			return fiber.ErrUpgradeRequired
+		}
+
+		h := captureHandshake(c, f.hub.options())
+		var keys map[any]any
+		if extract != nil {
+			keys = extract(c)
+			if err := f.hub.index.check(keys); err != nil {
+				if errors.Is(err, ErrSessionRejected) {
+					return fiber.NewError(fiber.StatusConflict, err.Error())
+				}
+				return fiber.NewError(fiber.StatusTooManyRequests, err.Error())
+			}
+		}
+		r, err := f.hub.admission.reserve(h.RealIP(), reservationTimeout)
+		if err != nil {
+			if errors.Is(err, ErrTooManyConnectionsPerIP) {
+				return fiber.NewError(fiber.StatusTooManyRequests, err.Error())
+			}
+			c.Set(fiber.HeaderRetryAfter, "1")
+			return fiber.NewError(fiber.StatusServiceUnavailable, err.Error())
+		}
+		h.reservation = r
+		// Unless the connection was hijacked, the connection handler will not run to claim
+		// the slot, e.g. when the upgrade failed or a Filter passed the request on.
+		defer func() {
+			if !c.Context().Hijacked() {
+				r.release()
+			}
+		}()
+
+		c.Locals(handshakeLocalsKey, h)
+		c.Locals(keysLocalsKey, keys)
+		return upgrade(c)
+	}
+}
+
+// Events returns the lifecycle event stream. Any number of subscribers can observe
+// connects, disconnects, subscriptions, dropped messages and errors, and can be
+// added or removed safely at runtime.
+func (f *Fibril) Events() *Events {
+	return f.hub.events
 }
 
 // TextMessageHandler sets the handler function for incoming text messages from clients.
 func (f *Fibril) TextMessageHandler(handler func(*Client, string)) {
-	f.option.textMessageHandler = handler
+	f.hub.updateOptions(func(o *option) {
+		o.textMessageHandler = handler
+	})
 }
 
 // BinaryMessageHandler sets the handler function for incoming binary messages from clients.
 func (f *Fibril) BinaryMessageHandler(handler func(*Client, []byte)) {
-	f.option.binaryMessageHandler = handler
+	f.hub.updateOptions(func(o *option) {
+		o.binaryMessageHandler = handler
+	})
+}
+
+// BinaryStreamHandler sets the handler for binary messages larger than the maximum message size.
+// Such messages are not buffered: the handler reads them from the connection as they arrive,
+// up to the size set by WithMaxStreamSize. Smaller messages still go to BinaryMessageHandler.
+func (f *Fibril) BinaryStreamHandler(handler func(*Client, io.Reader) error) {
+	f.hub.updateOptions(func(o *option) {
+		o.binaryStreamHandler = handler
+	})
 }
 
 // ErrorHandler sets the handler function for managing errors.
 func (f *Fibril) ErrorHandler(handler handleErrorFunc) {
-	f.option.errorHandler = handler
+	f.hub.updateOptions(func(o *option) {
+		o.errorHandler = handler
+	})
 }
 
 // CloseHandler sets the handler function for client connection closures.
 func (f *Fibril) CloseHandler(handler handleCloseFunc) {
-	f.option.closeHandler = handler
+	f.hub.updateOptions(func(o *option) {
+		o.closeHandler = handler
+	})
 }
 
 // ConnectHandler sets the handler function triggered when a client connects.
 func (f *Fibril) ConnectHandler(handler handleClientFunc) {
-	f.option.connectHandler = handler
+	f.hub.updateOptions(func(o *option) {
+		o.connectHandler = handler
+	})
 }
 
 // DisconnectHandler sets the handler function triggered when a client disconnects.
 func (f *Fibril) DisconnectHandler(handler handleClientFunc) {
-	f.option.disconnectHandler = handler
+	f.hub.updateOptions(func(o *option) {
+		o.disconnectHandler = handler
+	})
 }
 
 // PongHandler sets the handler function for managing WebSocket pong messages.
 func (f *Fibril) PongHandler(handler handleClientFunc) {
-	f.option.pongHandler = handler
+	f.hub.updateOptions(func(o *option) {
+		o.pongHandler = handler
+	})
+}
+
+// IdleHandler sets the handler called when a client exceeds the idle timeout set with
+// WithIdleTimeout. It can warn the client, call Touch to extend it, or disconnect it;
+// if the client stays idle, the handler is called again after every further timeout.
+func (f *Fibril) IdleHandler(handler handleIdleFunc) {
+	f.hub.updateOptions(func(o *option) {
+		o.idleHandler = handler
+	})
 }
 
 // DisconnectAll disconnects all connected clients with the given close message.
@@ -127,27 +325,48 @@ func (f *Fibril) DisconnectAll(closeMsg string) {
 }
 
 // DisconnectClient disconnects a specific client identified by UUID with an optional close message.
+// When clustered, clients connected to other nodes are reached through the cluster.
 func (f *Fibril) DisconnectClient(closeMsg string, uuid string) error {
 	return f.hub.disconnectClient(closeMsg, uuid)
 }
 
+// DisconnectByKey disconnects every client whose indexed key holds the given value.
+func (f *Fibril) DisconnectByKey(closeMsg string, key any, value any) error {
+	return f.hub.disconnectByKey(closeMsg, key, value)
+}
+
 // DisconnectClientFilter disconnects clients that meet the specified filter condition with an optional close message.
 func (f *Fibril) DisconnectClientFilter(closeMsg string, fn filterFunc) {
 	f.hub.disconnectClientFilter(closeMsg, fn)
 }
 
 // New initializes a new Fibril instance with optional configuration functions.
+// The resulting settings are checked like Config.Validate, and New panics if they
+// are invalid, e.g. a ping period that is not less than the pong wait. Use
+// NewFromConfig to get an error instead.
 func New(args ...OptFunc) *Fibril {
+	f, err := newFibril(args)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// newFibril applies args on top of the default options, validates the result and
+// starts the hub.
+func newFibril(args []OptFunc) (*Fibril, error) {
 	opt := defaultOption() // Apply default options
 	for _, optFunc := range args {
 		optFunc(opt) // Apply custom options
 	}
+	if err := configFromOption(opt).Validate(); err != nil {
+		return nil, err
+	}
 
 	hub := newHub(opt) // Create the central hub
 	go hub.run()       // Start the hub event loop
 
 	return &Fibril{
-		option: opt,
-		hub:    hub,
-	}
+		hub: hub,
+	}, nil
 }
@@ -0,0 +1,98 @@
+package fibril
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// serve serves handler at /ws on a local port and returns the WebSocket URL.
+func serve(t *testing.T, handler fiber.Handler) string {
+	t.Helper()
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/ws", handler)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = app.Listener(ln) }()
+	t.Cleanup(func() { _ = app.Shutdown() })
+	return "ws://" + ln.Addr().String() + "/ws"
+}
+
+// serveNode serves f's handler, indexing the "user" query parameter, and returns
+// the WebSocket URL.
+func serveNode(t *testing.T, f *Fibril) string {
+	t.Helper()
+
+	return serve(t, f.HandlerWithKeys(func(c *fiber.Ctx) map[any]any {
+		return map[any]any{"user": c.Query("user")}
+	}))
+}
+
+// dial connects a WebSocket client as user.
+func dial(t *testing.T, url, user string) *fasthttpws.Conn {
+	t.Helper()
+
+	conn, _, err := fasthttpws.DefaultDialer.Dial(url+"?user="+user, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// readMessage reads the next message from conn, failing the test after a second.
+func readMessage(t *testing.T, conn *fasthttpws.Conn) (int, string) {
+	t.Helper()
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	typ, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return typ, string(msg)
+}
+
+// readText reads the payload of the next message from conn.
+func readText(t *testing.T, conn *fasthttpws.Conn) string {
+	t.Helper()
+
+	_, msg := readMessage(t, conn)
+	return msg
+}
+
+// eventually polls cond until it holds, failing the test after a second.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// readClose reads from conn until the server closes it, failing the test unless the
+// close frame carries code and reason.
+func readClose(t *testing.T, conn *fasthttpws.Conn, code int, reason string) {
+	t.Helper()
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *fasthttpws.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != code || closeErr.Text != reason {
+			t.Fatalf("connection ended with %v, want close %d %q", err, code, reason)
+		}
+		return
+	}
+}
@@ -0,0 +1,107 @@
+package fibril
+
+import (
+	fasthttpws "github.com/fasthttp/websocket"
+	"sync"
+)
+
+// forwardTarget is a client that receives a topic's messages directly as frames of type t.
+type forwardTarget struct {
+	client *Client
+	t      int
+}
+
+// topicForwards tracks clients subscribed with SubscribeText or SubscribeBinary.
+// Their messages bypass the Pub/Sub handlers so that Publish can frame each
+// message once and share the prepared frame across all of them.
+type topicForwards struct {
+	mu     sync.RWMutex
+	topics map[string]map[string]forwardTarget // topic -> client UUID -> target
+}
+
+// add forwards messages published on topic to the client as frames of type t.
+func (f *topicForwards) add(topic string, c *Client, t int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	targets, ok := f.topics[topic]
+	if !ok {
+		targets = make(map[string]forwardTarget)
+		f.topics[topic] = targets
+	}
+	targets[c.GetUUID()] = forwardTarget{client: c, t: t}
+}
+
+// remove stops forwarding topic to the client.
+func (f *topicForwards) remove(topic string, c *Client) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if targets, ok := f.topics[topic]; ok {
+		delete(targets, c.GetUUID())
+		if len(targets) == 0 {
+			delete(f.topics, topic)
+		}
+	}
+}
+
+// removeClient stops forwarding all topics to the client.
+func (f *topicForwards) removeClient(c *Client) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, topic := range c.Subscriptions() {
+		if targets, ok := f.topics[topic]; ok {
+			delete(targets, c.GetUUID())
+			if len(targets) == 0 {
+				delete(f.topics, topic)
+			}
+		}
+	}
+}
+
+// count returns the number of clients forwarded the given topic.
+func (f *topicForwards) count(topic string) int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return len(f.topics[topic])
+}
+
+// list returns the topics with at least one forwarded client.
+func (f *topicForwards) list() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	topics := make([]string, 0, len(f.topics))
+	for topic := range f.topics {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// deliver sends msg to every client forwarded the topic, preparing the frame
+// once per message type instead of once per client.
+func (f *topicForwards) deliver(topic string, msg []byte) {
+	f.mu.RLock()
+	targets := make([]forwardTarget, 0, len(f.topics[topic]))
+	for _, target := range f.topics[topic] {
+		targets = append(targets, target)
+	}
+	f.mu.RUnlock()
+
+	prepared := make(map[int]*fasthttpws.PreparedMessage, 2)
+	for _, target := range targets {
+		pm, ok := prepared[target.t]
+		if !ok {
+			pm = prepareMessage(target.t, msg)
+			prepared[target.t] = pm
+		}
+		target.client.writeMessage(box{t: target.t, msg: msg, pm: pm})
+	}
+}
+
+// newTopicForwards creates an empty forward registry.
+func newTopicForwards() *topicForwards {
+	return &topicForwards{topics: make(map[string]map[string]forwardTarget)}
+}
@@ -0,0 +1,281 @@
+package fibril
+
+import (
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// handshakeLocalsKey is the Fiber locals key used to hand a full handshake
+// snapshot from Handler over to newClient across the WebSocket upgrade.
+const handshakeLocalsKey = "fibril.handshake"
+
+// keysLocalsKey is the Fiber locals key used to hand the keys extracted by
+// HandlerWithKeys over to the upgraded connection.
+const keysLocalsKey = "fibril.keys"
+
+// defaultHandshakeHeaders lists the headers captured when a client is registered
+// without going through Handler, where the full header set is not available.
+var defaultHandshakeHeaders = []string{
+	"Origin",
+	"User-Agent",
+	"X-Forwarded-For",
+	"Forwarded",
+	"X-Real-Ip",
+	"Sec-Websocket-Protocol",
+}
+
+// HandshakeCapture lists the request values copied into a client's Handshake
+// when the connection is registered directly through RegisterClient.
+// Connections upgraded through Fibril.Handler capture everything and ignore it.
+type HandshakeCapture struct {
+	Headers []string // Header names to capture in addition to the defaults
+	Query   []string // Query parameter names to capture
+	Cookies []string // Cookie names to capture
+	Params  []string // Route parameter names to capture
+	Locals  []string // Fiber locals keys to capture
+}
+
+// Handshake is a read-only snapshot of the HTTP upgrade request of a client,
+// captured when the client connects.
+type Handshake struct {
+	headers     map[string]string // Request headers keyed by canonical name
+	query       map[string]string // Query string parameters
+	cookies     map[string]string // Request cookies
+	params      map[string]string // Route parameters
+	locals      map[string]any    // Fiber locals set before the upgrade
+	subprotocol string            // Negotiated WebSocket subprotocol
+	ip          string            // IP address of the direct peer
+	realIP      string            // Client IP resolved through trusted proxies
+	reservation *reservation      // Admission slot reserved by Handler, nil for RegisterClient
+}
+
+// Header returns the value of the request header with the given name.
+func (h *Handshake) Header(name string) string {
+	return h.headers[http.CanonicalHeaderKey(name)]
+}
+
+// Headers returns a copy of all captured request headers.
+func (h *Handshake) Headers() map[string]string {
+	return copyMap(h.headers)
+}
+
+// Query returns the value of the query parameter with the given name.
+func (h *Handshake) Query(name string) string {
+	return h.query[name]
+}
+
+// Queries returns a copy of all captured query parameters.
+func (h *Handshake) Queries() map[string]string {
+	return copyMap(h.query)
+}
+
+// Cookie returns the value of the cookie with the given name.
+func (h *Handshake) Cookie(name string) string {
+	return h.cookies[name]
+}
+
+// Cookies returns a copy of all captured cookies.
+func (h *Handshake) Cookies() map[string]string {
+	return copyMap(h.cookies)
+}
+
+// Param returns the value of the route parameter with the given name.
+func (h *Handshake) Param(name string) string {
+	return h.params[name]
+}
+
+// Params returns a copy of all captured route parameters.
+func (h *Handshake) Params() map[string]string {
+	return copyMap(h.params)
+}
+
+// Local returns the Fiber local stored under the given key before the upgrade.
+func (h *Handshake) Local(key string) any {
+	return h.locals[key]
+}
+
+// Subprotocol returns the WebSocket subprotocol negotiated during the upgrade.
+func (h *Handshake) Subprotocol() string {
+	return h.subprotocol
+}
+
+// IP returns the IP address of the direct peer of the connection.
+func (h *Handshake) IP() string {
+	return h.ip
+}
+
+// RealIP returns the client IP address, resolved from X-Forwarded-For or
+// Forwarded when the direct peer is a trusted proxy.
+func (h *Handshake) RealIP() string {
+	return h.realIP
+}
+
+// captureHandshake snapshots the full upgrade request from a Fiber context.
+func captureHandshake(c *fiber.Ctx, opt *option) *Handshake {
+	h := &Handshake{
+		headers: make(map[string]string),
+		query:   make(map[string]string),
+		cookies: make(map[string]string),
+		params:  make(map[string]string),
+		locals:  make(map[string]any),
+		ip:      c.IP(),
+	}
+
+	c.Request().Header.VisitAll(func(key, value []byte) {
+		h.headers[http.CanonicalHeaderKey(string(key))] = string(value)
+	})
+	c.Context().QueryArgs().VisitAll(func(key, value []byte) {
+		h.query[string(key)] = string(value)
+	})
+	c.Request().Header.VisitAllCookie(func(key, value []byte) {
+		h.cookies[string(key)] = string(value)
+	})
+	for _, name := range c.Route().Params {
+		h.params[name] = strings.Clone(c.Params(name))
+	}
+	c.Context().VisitUserValues(func(key []byte, value any) {
+		h.locals[string(key)] = value
+	})
+	h.realIP = resolveRealIP(h.ip, h.headers, opt.trustedProxies)
+
+	return h
+}
+
+// newHandshake builds the handshake snapshot for a newly registered client.
+// It reuses the snapshot captured by Handler when present, otherwise it
+// collects the values named by the handshake capture option from conn.
+func newHandshake(conn *websocket.Conn, opt *option) *Handshake {
+	h, ok := conn.Locals(handshakeLocalsKey).(*Handshake)
+	if ok {
+		delete(h.locals, handshakeLocalsKey)
+	} else {
+		h = &Handshake{
+			headers: make(map[string]string),
+			query:   make(map[string]string),
+			cookies: make(map[string]string),
+			params:  make(map[string]string),
+			locals:  make(map[string]any),
+			ip:      conn.IP(),
+		}
+
+		capture := opt.handshakeCapture
+		for _, name := range slices.Concat(defaultHandshakeHeaders, capture.Headers) {
+			name = http.CanonicalHeaderKey(name)
+			if v := conn.Headers(name); v != "" {
+				h.headers[name] = v
+			}
+		}
+		for _, name := range capture.Query {
+			if v := conn.Query(name); v != "" {
+				h.query[name] = v
+			}
+		}
+		for _, name := range capture.Cookies {
+			if v := conn.Cookies(name); v != "" {
+				h.cookies[name] = v
+			}
+		}
+		for _, name := range capture.Params {
+			if v := conn.Params(name); v != "" {
+				h.params[name] = v
+			}
+		}
+		for _, key := range capture.Locals {
+			if v := conn.Locals(key); v != nil {
+				h.locals[key] = v
+			}
+		}
+	}
+
+	if h.ip == "" {
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+			h.ip = addr.IP.String()
+		}
+	}
+	h.subprotocol = conn.Subprotocol()
+	h.realIP = resolveRealIP(h.ip, h.headers, opt.trustedProxies)
+
+	return h
+}
+
+// resolveRealIP walks X-Forwarded-For (or Forwarded) from right to left while
+// the hops are trusted proxies and returns the first untrusted address.
+// If the direct peer is not a trusted proxy, its address is returned as is.
+func resolveRealIP(peer string, headers map[string]string, trusted []netip.Prefix) string {
+	if !isTrustedProxy(peer, trusted) {
+		return peer
+	}
+
+	var hops []string
+	if xff := headers["X-Forwarded-For"]; xff != "" {
+		for _, hop := range strings.Split(xff, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	} else if fwd := headers["Forwarded"]; fwd != "" {
+		hops = parseForwardedFor(fwd)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			continue
+		}
+		if i == 0 || !isTrustedProxy(addr.String(), trusted) {
+			return addr.String()
+		}
+	}
+
+	return peer
+}
+
+// parseForwardedFor extracts the "for" addresses of an RFC 7239 Forwarded header.
+func parseForwardedFor(value string) []string {
+	var hops []string
+	for _, element := range strings.Split(value, ",") {
+		for _, pair := range strings.Split(element, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || !strings.EqualFold(k, "for") {
+				continue
+			}
+			v = strings.Trim(v, `"`)
+			if strings.HasPrefix(v, "[") {
+				if end := strings.Index(v, "]"); end > 0 {
+					v = v[1:end]
+				}
+			} else if host, _, err := net.SplitHostPort(v); err == nil {
+				v = host
+			}
+			hops = append(hops, v)
+		}
+	}
+	return hops
+}
+
+// isTrustedProxy reports whether ip falls within one of the trusted prefixes.
+func isTrustedProxy(ip string, trusted []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyMap returns a shallow copy of m.
+func copyMap(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
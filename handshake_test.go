@@ -0,0 +1,96 @@
+package fibril
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"testing"
+
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestResolveRealIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+
+	tests := []struct {
+		name    string
+		peer    string
+		headers map[string]string
+		want    string
+	}{
+		{"untrusted peer", "203.0.113.9", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "203.0.113.9"},
+		{"no header", "10.0.0.1", nil, "10.0.0.1"},
+		{"xff", "10.0.0.1", map[string]string{"X-Forwarded-For": "198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"spoofed xff", "10.0.0.1", map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"all trusted", "10.0.0.1", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"garbage hop", "10.0.0.1", map[string]string{"X-Forwarded-For": "198.51.100.1, unknown"}, "198.51.100.1"},
+		{"forwarded", "::1", map[string]string{"Forwarded": `for=198.51.100.1:4711;proto=https, for="[2001:db8::1]:80"`}, "2001:db8::1"},
+		{"xff over forwarded", "10.0.0.1", map[string]string{"X-Forwarded-For": "198.51.100.1", "Forwarded": "for=198.51.100.2"}, "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveRealIP(tt.peer, tt.headers, trusted); got != tt.want {
+				t.Fatalf("resolveRealIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandshakeSnapshot(t *testing.T) {
+	f := New(WithTrustedProxies("127.0.0.1"))
+	handshakes := make(chan *Handshake, 1)
+	f.ConnectHandler(func(c *Client) { handshakes <- c.Handshake() })
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/rooms/:room", func(c *fiber.Ctx) error {
+		c.Locals("tenant", "acme")
+		return c.Next()
+	}, f.Handler())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = app.Listener(ln) }()
+	t.Cleanup(func() { _ = app.Shutdown() })
+
+	header := http.Header{}
+	header.Set("X-Request-Id", "abc")
+	header.Set("X-Forwarded-For", "198.51.100.7")
+	header.Set("Cookie", "session=s3cr3t; theme=dark")
+	conn, _, err := fasthttpws.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/rooms/42?lang=en", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	h := <-handshakes
+
+	checks := []struct {
+		name, got, want string
+	}{
+		{"header", h.Header("x-request-id"), "abc"},
+		{"query", h.Query("lang"), "en"},
+		{"cookie", h.Cookie("theme"), "dark"},
+		{"param", h.Param("room"), "42"},
+		{"ip", h.IP(), "127.0.0.1"},
+		{"real ip", h.RealIP(), "198.51.100.7"},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %q, want %q", c.name, c.got, c.want)
+		}
+	}
+	if v := h.Local("tenant"); v != "acme" {
+		t.Errorf("local tenant = %v, want acme", v)
+	}
+	if v := h.Local(handshakeLocalsKey); v != nil {
+		t.Errorf("the snapshot holds itself in its locals: %v", v)
+	}
+
+	// The accessors return copies: the snapshot stays read-only.
+	h.Headers()["X-Request-Id"] = "changed"
+	h.Cookies()["theme"] = "light"
+	if h.Header("X-Request-Id") != "abc" || h.Cookie("theme") != "dark" {
+		t.Fatal("modifying a returned map changed the snapshot")
+	}
+}
@@ -0,0 +1,226 @@
+package fibril
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// NodeStatus is the state a node reports in its heartbeats.
+type NodeStatus struct {
+	Node    string              `json:"node"`             // Name of the node
+	Clients int                 `json:"clients"`          // Number of connected clients
+	Topics  map[string]int      `json:"topics,omitempty"` // Subscriber count per active topic
+	Online  map[string][]string `json:"online,omitempty"` // Values held by local clients per presence key
+	Time    time.Time           `json:"time"`             // When the status was taken
+}
+
+// nodeTable holds the latest heartbeat of every peer and when it was received.
+type nodeTable struct {
+	mu      sync.RWMutex
+	nodes   map[string]NodeStatus
+	seen    map[string]time.Time
+	expired map[string]bool // Peers expired since their last heartbeat
+	ttl     time.Duration
+}
+
+// update records a heartbeat and reports whether the peer had expired before it.
+func (t *nodeTable) update(s NodeStatus, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.nodes[s.Node] = s
+	t.seen[s.Node] = now
+	rejoined := t.expired[s.Node]
+	delete(t.expired, s.Node)
+	return rejoined
+}
+
+// alive reports whether a heartbeat was received from node within the TTL.
+func (t *nodeTable) alive(node string, now time.Time) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	seen, ok := t.seen[node]
+	return ok && now.Sub(seen) <= t.ttl
+}
+
+// expire removes and returns the nodes whose last heartbeat is older than the TTL.
+func (t *nodeTable) expire(now time.Time) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var expired []string
+	for node, seen := range t.seen {
+		if now.Sub(seen) > t.ttl {
+			expired = append(expired, node)
+			delete(t.seen, node)
+			delete(t.nodes, node)
+			t.expired[node] = true
+		}
+	}
+	return expired
+}
+
+// live returns the latest status of every live peer.
+func (t *nodeTable) live(now time.Time) []NodeStatus {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	out := make([]NodeStatus, 0, len(t.nodes))
+	for node, s := range t.nodes {
+		if now.Sub(t.seen[node]) <= t.ttl {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// newNodeTable creates an empty node table expiring peers after ttl.
+func newNodeTable(ttl time.Duration) *nodeTable {
+	return &nodeTable{
+		nodes:   make(map[string]NodeStatus),
+		seen:    make(map[string]time.Time),
+		expired: make(map[string]bool),
+		ttl:     ttl,
+	}
+}
+
+// alive reports whether node is this node or a peer with a recent heartbeat.
+// Without heartbeats every node is assumed to be alive.
+func (c *cluster) alive(node string) bool {
+	if node == c.node || c.nodes == nil {
+		return true
+	}
+	return c.nodes.alive(node, time.Now())
+}
+
+// peers returns the latest status of every live peer, or nil without heartbeats.
+func (c *cluster) peers() []NodeStatus {
+	if c == nil || c.nodes == nil {
+		return nil
+	}
+	return c.nodes.live(time.Now())
+}
+
+// heartbeatLoop broadcasts this node's status every interval and expires peers
+// that have not sent a heartbeat within the TTL.
+func (c *cluster) heartbeatLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		c.heartbeat()
+		for _, node := range c.nodes.expire(time.Now()) {
+			c.nodeDown(node)
+		}
+		<-ticker.C
+	}
+}
+
+// heartbeat broadcasts this node's status to its peers.
+func (c *cluster) heartbeat() {
+	data, err := json.Marshal(c.hub.status())
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := c.context()
+	defer cancel()
+	_ = c.transport.Broadcast(ctx, ClusterMessage{Op: ClusterHeartbeat, From: c.node, Data: data})
+}
+
+// receiveHeartbeat records a heartbeat received from a peer.
+func (c *cluster) receiveHeartbeat(msg ClusterMessage) error {
+	if c.nodes == nil {
+		return nil
+	}
+	var s NodeStatus
+	if err := json.Unmarshal(msg.Data, &s); err != nil {
+		return err
+	}
+	s.Node = msg.From
+	if c.nodes.update(s, time.Now()) {
+		// The peer's clients were purged when it expired: have it register them again.
+		go c.requestResync(msg.From)
+	}
+	return nil
+}
+
+// requestResync asks node to register all its clients in the directory again.
+func (c *cluster) requestResync(node string) {
+	ctx, cancel := c.context()
+	defer cancel()
+	_ = c.transport.Send(ctx, node, ClusterMessage{Op: ClusterResync, From: c.node})
+}
+
+// resync queues a directory write for every local client, restoring entries that a
+// peer purged while it considered this node dead.
+func (c *cluster) resync() {
+	c.hub.forEachClient(func(_ string, client *Client) {
+		c.register(client)
+	})
+}
+
+// nodeDown purges the directory entries of an expired node and announces it on
+// the presence topics.
+func (c *cluster) nodeDown(node string) {
+	if remover, ok := c.directory.(nodeRemover); ok {
+		ctx, cancel := c.context()
+		_ = remover.RemoveNode(ctx, node)
+		cancel()
+	}
+	c.hub.announceNodeDown(node)
+}
+
+// status returns a snapshot of this node's state for heartbeats.
+func (h *Hub) status() NodeStatus {
+	s := NodeStatus{
+		Clients: h.clientMap.Len(),
+		Topics:  make(map[string]int),
+		Online:  make(map[string][]string),
+		Time:    time.Now(),
+	}
+	if h.cluster != nil {
+		s.Node = h.cluster.node
+	}
+	for _, topic := range h.listTopics() {
+		s.Topics[topic] = h.subscriberCount(topic)
+	}
+	for key := range h.options().keyPresence {
+		s.Online[formatKey(key)] = h.onlineLocal(key)
+	}
+	return s
+}
+
+// nodes returns the status of this node followed by every live peer.
+func (h *Hub) nodes() []NodeStatus {
+	return append([]NodeStatus{h.status()}, h.cluster.peers()...)
+}
+
+// clusterClientLen returns the number of clients connected to all live nodes.
+func (h *Hub) clusterClientLen() int {
+	n := h.clientMap.Len()
+	for _, s := range h.cluster.peers() {
+		n += s.Clients
+	}
+	return n
+}
+
+// clusterSubscriberCount returns the number of subscribers of a topic on all live nodes.
+func (h *Hub) clusterSubscriberCount(topic string) int {
+	n := h.subscriberCount(topic)
+	for _, s := range h.cluster.peers() {
+		n += s.Topics[topic]
+	}
+	return n
+}
+
+// broadcastPublish publishes msg to topic locally and queues it for every other node.
+func (h *Hub) broadcastPublish(topic string, msg []byte) {
+	_ = h.publish(topic, msg)
+	if h.cluster == nil {
+		return
+	}
+	h.cluster.queue.broadcast(ClusterMessage{Op: ClusterPublish, From: h.cluster.node, Topic: topic, Data: msg})
+}
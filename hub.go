@@ -5,24 +5,57 @@ import (
 	"github.com/gofiber/contrib/websocket"
 	"github.com/lishank0119/pubsub"
 	"github.com/lishank0119/shardingmap"
+	"slices"
+	"sync"
+	"sync/atomic"
 )
 
 // Hub manages WebSocket clients, broadcasting messages, and Pub/Sub communications.
 type Hub struct {
-	opt       *option                                   // Configuration options for the Hub
-	clientMap *shardingmap.ShardingMap[string, *Client] // A sharded map to efficiently manage connected clients
-	broadcast chan box                                  // Channel for broadcasting messages to clients
-	pubSub    *pubsub.PubSub                            // Internal Pub/Sub system for message distribution
+	opt           atomic.Pointer[option]                    // Current configuration options, replaced as a whole on every change
+	optMu         sync.Mutex                                // Serializes option updates
+	clientMap     *shardingmap.ShardingMap[string, *Client] // A sharded map to efficiently manage connected clients
+	broadcast     chan box                                  // Channel for broadcasting messages to clients
+	pubSub        *pubsub.PubSub                            // Internal Pub/Sub system for message distribution
+	index         *keyIndex                                 // Secondary indexes on declared client keys
+	admission     *admission                                // Connection caps and accept rate limit
+	forwards      *topicForwards                            // Clients receiving topic messages directly as frames
+	events        *Events                                   // Lifecycle event fan-out
+	topicPresence *presenceTable                            // Presence metadata of clients per topic
+	cluster       *cluster                                  // Routing to clients on other nodes, nil if not clustered
+}
+
+// options returns the current configuration options.
+func (h *Hub) options() *option {
+	return h.opt.Load()
+}
+
+// updateOptions applies fn to a copy of the current options and swaps the copy in,
+// so readers never observe a partially updated option set.
+func (h *Hub) updateOptions(fn func(*option)) {
+	h.optMu.Lock()
+	defer h.optMu.Unlock()
+
+	next := h.options().clone()
+	fn(next)
+	h.opt.Store(next)
+	h.admission.configure(next)
 }
 
 // subscriberCount returns the number of subscribers for a given topic.
 func (h *Hub) subscriberCount(topic string) int {
-	return h.pubSub.SubscriberCount(topic)
+	return h.pubSub.SubscriberCount(topic) + h.forwards.count(topic)
 }
 
 // listTopics returns a list of all active topics currently subscribed to.
 func (h *Hub) listTopics() []string {
-	return h.pubSub.ListTopics()
+	topics := h.pubSub.ListTopics()
+	for _, topic := range h.forwards.list() {
+		if !slices.Contains(topics, topic) {
+			topics = append(topics, topic)
+		}
+	}
+	return topics
 }
 
 // getClient returns the client associated with the given UUID.
@@ -53,8 +86,10 @@ func (h *Hub) clientLen() int {
 	return h.clientMap.Len()
 }
 
-// publish sends a message to a specific topic using the Pub/Sub system.
+// publish sends a message to a specific topic. Clients subscribed with SubscribeText or
+// SubscribeBinary receive one shared prepared frame; handler subscribers go through Pub/Sub.
 func (h *Hub) publish(topic string, msg []byte) error {
+	h.forwards.deliver(topic, msg)
 	return h.pubSub.Publish(topic, msg)
 }
 
@@ -63,6 +98,13 @@ func (h *Hub) run() {
 	for {
 		select {
 		case b := <-h.broadcast:
+			b.pm = prepareMessage(b.t, b.msg) // Frame once, write the same frame to every recipient
+			if b.to != nil {
+				for _, client := range b.to {
+					client.writeMessage(b)
+				}
+				continue
+			}
 			h.clientMap.ForEach(func(uuid string, client *Client) {
 				if b.filter == nil {
 					client.writeMessage(b) // Send message to all clients if no filter is set
@@ -74,15 +116,50 @@ func (h *Hub) run() {
 	}
 }
 
-// registerClient adds a new client to the client map.
-func (h *Hub) registerClient(client *Client) {
+// registerClient adds a new client to the key index and the client map.
+// Session policies are enforced here: the client is rejected with an error,
+// or existing clients sharing its identity key are kicked.
+func (h *Hub) registerClient(client *Client) error {
+	if r := client.handshake.reservation; r == nil || !r.claim() {
+		if err := h.admission.acquire(client.handshake.RealIP()); err != nil {
+			return err
+		}
+	}
+	client.admitted = true
+
+	kicked, err := h.index.add(client)
+	if err != nil {
+		client.admitted = false
+		h.admission.release(client.handshake.RealIP())
+		return err
+	}
 	h.clientMap.Set(client.GetUUID(), client)
+	h.cluster.register(client)
+	h.announceKeys(client, KeyPresenceJoin)
+
+	h.kick(kicked)
+	return nil
+}
+
+// kick disconnects clients replaced by a newer session under the given rules.
+func (h *Hub) kick(kicked map[*Client]sessionRule) {
+	for old, rule := range kicked {
+		old.disconnect(&DisconnectError{Err: ErrSessionReplaced, Reason: rule.closeReason}, websocket.ClosePolicyViolation, rule.closeReason)
+	}
 }
 
 // unregisterClient removes a client from the client map and triggers the disconnect handler.
 func (h *Hub) unregisterClient(client *Client) {
 	h.clientMap.Delete(client.GetUUID())
-	h.opt.disconnectHandler(client)
+	h.cluster.unregister(client)
+	h.announceKeys(client, KeyPresenceLeave)
+	h.index.remove(client)
+	if client.admitted {
+		client.admitted = false
+		h.admission.release(client.handshake.RealIP())
+	}
+	client.onDisconnect()
+	h.events.emit(Event{Type: EventDisconnected, Client: client, Err: client.Cause()})
 }
 
 // disconnectAll disconnects all clients with the given close message.
@@ -102,44 +179,183 @@ func (h *Hub) disconnectClientFilter(closeMsg string, fn filterFunc) {
 	})
 }
 
-// disconnectClient disconnects a specific client identified by its UUID.
+// disconnectClient disconnects a specific client identified by its UUID,
+// on whichever node it is connected to.
 func (h *Hub) disconnectClient(closeMsg string, uuid string) error {
 	if client, ok := h.clientMap.Get(uuid); ok {
 		client.Disconnect(closeMsg)
 		return nil
 	}
-	return ErrClientNotFound
+	return h.cluster.forward(uuid, ClusterMessage{Op: ClusterDisconnect, Reason: closeMsg})
 }
 
 // broadcastText sends a text message to all clients that match the filter function.
 // If no filter is provided, the message will be sent to all clients.
-func (h *Hub) broadcastText(msg string, fn func(*Client) bool) {
+func (h *Hub) broadcastText(msg string, fn func(*Client) bool, opts []BroadcastOption) {
 	message := box{t: websocket.TextMessage, msg: []byte(msg), filter: fn}
+	for _, opt := range opts {
+		opt(&message)
+	}
 	h.broadcast <- message
 }
 
 // broadcastBinary sends a binary message to all clients that match the filter function.
 // If no filter is provided, the message will be sent to all clients.
-func (h *Hub) broadcastBinary(msg []byte, fn func(*Client) bool) {
+func (h *Hub) broadcastBinary(msg []byte, fn func(*Client) bool, opts []BroadcastOption) {
 	message := box{t: websocket.BinaryMessage, msg: msg, filter: fn}
+	for _, opt := range opts {
+		opt(&message)
+	}
 	h.broadcast <- message
 }
 
-// sendTextToClient sends a text message to a specific client identified by its UUID.
+// sendTextToClient sends a text message to a specific client identified by its UUID,
+// on whichever node it is connected to.
 func (h *Hub) sendTextToClient(uuid string, msg string) error {
 	if client, ok := h.clientMap.Get(uuid); ok {
 		client.writeMessage(box{t: websocket.TextMessage, msg: []byte(msg)})
 		return nil
 	}
-	return ErrClientNotFound
+	return h.cluster.forward(uuid, ClusterMessage{Op: ClusterSend, Type: websocket.TextMessage, Data: []byte(msg)})
 }
 
-// sendBinaryToClient sends a binary message to a specific client identified by its UUID.
+// sendBinaryToClient sends a binary message to a specific client identified by its UUID,
+// on whichever node it is connected to.
 func (h *Hub) sendBinaryToClient(uuid string, msg []byte) error {
 	if client, ok := h.clientMap.Get(uuid); ok {
 		client.writeMessage(box{t: websocket.BinaryMessage, msg: msg})
 		return nil
 	}
+	return h.cluster.forward(uuid, ClusterMessage{Op: ClusterSend, Type: websocket.BinaryMessage, Data: msg})
+}
+
+// clientsByKey returns the clients whose indexed key holds the given value.
+func (h *Hub) clientsByKey(key any, value any) ([]*Client, error) {
+	if !h.index.indexed(key) {
+		return nil, ErrKeyNotIndexed
+	}
+	return h.index.lookup(key, value), nil
+}
+
+// sendToKey enqueues a message for every client on any node whose indexed key holds the given value.
+func (h *Hub) sendToKey(key any, value any, message box) error {
+	clients, err := h.clientsByKey(key, value)
+	if err != nil {
+		return err
+	}
+	for _, client := range clients {
+		client.writeMessage(message)
+	}
+
+	remote, err := h.cluster.forwardKey(key, value, ClusterMessage{Op: ClusterSend, Type: message.t, Data: message.msg})
+	if err != nil {
+		return err
+	}
+	if len(clients)+remote == 0 {
+		return ErrClientNotFound
+	}
+	return nil
+}
+
+// sendTextToKey sends a text message to every client whose indexed key holds the given value.
+func (h *Hub) sendTextToKey(key any, value any, msg string) error {
+	return h.sendToKey(key, value, box{t: websocket.TextMessage, msg: []byte(msg)})
+}
+
+// sendBinaryToKey sends a binary message to every client whose indexed key holds the given value.
+func (h *Hub) sendBinaryToKey(key any, value any, msg []byte) error {
+	return h.sendToKey(key, value, box{t: websocket.BinaryMessage, msg: msg})
+}
+
+// broadcastToKey queues a message on the broadcast channel for the local clients
+// whose indexed key holds the given value, and forwards it to clients on other nodes.
+func (h *Hub) broadcastToKey(key any, value any, t int, msg []byte) error {
+	clients, err := h.clientsByKey(key, value)
+	if err != nil {
+		return err
+	}
+	if len(clients) > 0 {
+		h.broadcast <- box{t: t, msg: msg, to: clients}
+	}
+
+	remote, err := h.cluster.forwardKey(key, value, ClusterMessage{Op: ClusterSend, Type: t, Data: msg})
+	if err != nil {
+		return err
+	}
+	if len(clients)+remote == 0 {
+		return ErrClientNotFound
+	}
+	return nil
+}
+
+// disconnectByKey disconnects every client on any node whose indexed key holds the given value.
+func (h *Hub) disconnectByKey(closeMsg string, key any, value any) error {
+	clients, err := h.clientsByKey(key, value)
+	if err != nil {
+		return err
+	}
+	for _, client := range clients {
+		client.Disconnect(closeMsg)
+	}
+
+	remote, err := h.cluster.forwardKey(key, value, ClusterMessage{Op: ClusterDisconnect, Reason: closeMsg})
+	if err != nil {
+		return err
+	}
+	if len(clients)+remote == 0 {
+		return ErrClientNotFound
+	}
+	return nil
+}
+
+// locateClient returns the location of a client connected to this or another node.
+func (h *Hub) locateClient(uuid string) (ClientLocation, error) {
+	if client, ok := h.clientMap.Get(uuid); ok {
+		if h.cluster != nil {
+			return h.cluster.location(client), nil
+		}
+		return ClientLocation{UUID: uuid, ConnectedAt: client.ConnectedAt()}, nil
+	}
+	return h.cluster.locate(uuid)
+}
+
+// locateKey returns the locations of the clients on any node whose indexed key holds the given value.
+func (h *Hub) locateKey(key any, value any) ([]ClientLocation, error) {
+	if !h.index.indexed(key) {
+		return nil, ErrKeyNotIndexed
+	}
+
+	// Local clients are answered from the index, which is ahead of the directory.
+	clients := h.index.lookup(key, value)
+	locs := make([]ClientLocation, 0, len(clients))
+	for _, client := range clients {
+		if h.cluster != nil {
+			locs = append(locs, h.cluster.location(client))
+		} else {
+			locs = append(locs, ClientLocation{UUID: client.GetUUID(), ConnectedAt: client.ConnectedAt()})
+		}
+	}
+	if h.cluster == nil {
+		return locs, nil
+	}
+
+	remote, err := h.cluster.locateKey(key, value)
+	if err != nil {
+		return nil, err
+	}
+	for _, loc := range remote {
+		if loc.Node != h.cluster.node {
+			locs = append(locs, loc)
+		}
+	}
+	return locs, nil
+}
+
+// sendToClientContext sends a message to a specific client, blocking until it is queued or written.
+func (h *Hub) sendToClientContext(ctx context.Context, uuid string, message box, opts []SendOption) error {
+	if client, ok := h.clientMap.Get(uuid); ok {
+		return client.sendContext(ctx, message, opts)
+	}
 	return ErrClientNotFound
 }
 
@@ -149,13 +365,21 @@ func newHub(opt *option) *Hub {
 		shardingmap.WithShardCount[string, *Client](opt.shardCount),
 	)
 
-	return &Hub{
-		opt:       opt,
+	h := &Hub{
 		clientMap: m,
 		pubSub: pubsub.NewPubSub(&pubsub.Config{
 			BucketNum:           opt.shardCount,            // Number of buckets for sharding Pub/Sub messages
 			BucketMessageBuffer: opt.messageBufferSize * 2, // Buffer size for each Pub/Sub bucket
 		}),
-		broadcast: make(chan box), // Channel for broadcasting messages
+		broadcast:     make(chan box), // Channel for broadcasting messages
+		index:         newKeyIndex(opt.indexedKeys, opt.sessionRules, opt.keyLimits),
+		admission:     newAdmission(opt),
+		forwards:      newTopicForwards(),
+		events:        &Events{},
+		topicPresence: newPresenceTable(),
 	}
+	h.opt.Store(opt)
+	h.cluster = newCluster(h, opt)
+	h.cluster.start()
+	return h
 }
@@ -0,0 +1,111 @@
+package fibril
+
+import (
+	"github.com/gofiber/contrib/websocket"
+	"math/rand/v2"
+	"time"
+)
+
+// touch records application activity on the connection.
+func (c *Client) touch() {
+	c.lastActivity.Store(time.Now().UnixNano())
+}
+
+// Touch resets the client's idle timer as if a message had been exchanged, e.g. from
+// an IdleHandler that decides to keep the client connected.
+func (c *Client) Touch() {
+	c.touch()
+}
+
+// LastActivity returns the time of the last application message sent or received by
+// the client. Pings, pongs and application-level heartbeats do not count.
+func (c *Client) LastActivity() time.Time {
+	return time.Unix(0, c.lastActivity.Load())
+}
+
+// startTimers arms the idle and connection lifetime timers of a new connection.
+func (c *Client) startTimers() {
+	c.touch()
+	c.armTimers()
+}
+
+// armTimers arms, re-arms or stops the idle and lifetime timers to match the current
+// options. It runs when the client connects and again when Reconfigure changes them,
+// measuring from the last activity and the connect time respectively.
+func (c *Client) armTimers() {
+	c.timerMu.Lock()
+	defer c.timerMu.Unlock()
+
+	if c.stopped {
+		return
+	}
+
+	if timeout := c.opt().idleTimeout; timeout > 0 {
+		next := max(timeout-time.Since(c.LastActivity()), 0)
+		if c.idleTimer == nil {
+			c.idleTimer = time.AfterFunc(next, c.checkIdle)
+		} else {
+			c.idleTimer.Reset(next)
+		}
+	} else if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+
+	if lifetime := c.opt().maxLifetime; lifetime > 0 {
+		if jitter := c.opt().lifetimeJitter; jitter > 0 && !c.jittered {
+			c.lifetimeJitter, c.jittered = rand.N(jitter), true
+		}
+		next := max(time.Until(c.connectedAt.Add(lifetime+c.lifetimeJitter)), 0)
+		if c.lifetimeTimer == nil {
+			c.lifetimeTimer = time.AfterFunc(next, func() {
+				c.disconnect(&DisconnectError{Err: ErrMaxLifetime, Reason: "connection lifetime exceeded"}, websocket.CloseGoingAway, "connection lifetime exceeded")
+			})
+		} else {
+			c.lifetimeTimer.Reset(next)
+		}
+	} else if c.lifetimeTimer != nil {
+		c.lifetimeTimer.Stop()
+	}
+}
+
+// stopTimers stops the idle and lifetime timers.
+func (c *Client) stopTimers() {
+	c.timerMu.Lock()
+	defer c.timerMu.Unlock()
+
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+	if c.lifetimeTimer != nil {
+		c.lifetimeTimer.Stop()
+	}
+	c.stopped = true
+}
+
+// checkIdle runs when the idle timer fires. If the client has been idle for the full
+// timeout, the idle handler is called, and the timer is re-armed for the next check.
+func (c *Client) checkIdle() {
+	timeout := c.opt().idleTimeout
+	if timeout <= 0 || !c.isOpen() {
+		return
+	}
+
+	next := timeout
+	if idle := time.Since(c.LastActivity()); idle >= timeout {
+		if handler := c.opt().idleHandler; handler != nil {
+			handler(c, idle)
+		} else {
+			c.disconnect(&DisconnectError{Err: ErrIdleTimeout, Reason: "idle timeout"}, websocket.CloseGoingAway, "idle timeout")
+			return
+		}
+	} else {
+		next = timeout - idle
+	}
+
+	c.timerMu.Lock()
+	defer c.timerMu.Unlock()
+
+	if !c.stopped {
+		c.idleTimer.Reset(next)
+	}
+}
@@ -0,0 +1,104 @@
+package fibril
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	f := New(WithIdleTimeout(30 * time.Millisecond))
+	conn := dial(t, serveNode(t, f), "u")
+	start := time.Now()
+
+	readClose(t, conn, websocket.CloseGoingAway, "idle timeout")
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Fatalf("disconnected after %v, before the idle timeout", d)
+	}
+}
+
+func TestIdleHandlerTouch(t *testing.T) {
+	const timeout = 30 * time.Millisecond
+	f := New(WithIdleTimeout(timeout))
+	calls := make(chan time.Time, 3)
+	f.IdleHandler(func(c *Client, idle time.Duration) {
+		if idle < timeout {
+			t.Errorf("idle handler called after %v idle, before the timeout", idle)
+		}
+		calls <- time.Now()
+		if len(calls) < cap(calls) {
+			c.Touch() // Extend the client for another timeout.
+			return
+		}
+		c.Disconnect("still idle")
+	})
+	conn := dial(t, serveNode(t, f), "u")
+
+	readClose(t, conn, websocket.CloseNormalClosure, "still idle")
+	prev := <-calls
+	for i := 1; i < cap(calls); i++ {
+		at := <-calls
+		if d := at.Sub(prev); d < timeout {
+			t.Fatalf("idle handler called again %v after Touch, before the timeout", d)
+		}
+		prev = at
+	}
+}
+
+func TestLifetimeJitter(t *testing.T) {
+	const lifetime, jitter = 20 * time.Millisecond, 40 * time.Millisecond
+	f := New(WithMaxConnectionLifetime(lifetime, jitter))
+	clients := make(chan *Client, 5)
+	f.ConnectHandler(func(c *Client) { clients <- c })
+	url := serveNode(t, f)
+
+	for i := 0; i < cap(clients); i++ {
+		conn := dial(t, url, "u")
+		c := <-clients
+		c.timerMu.Lock()
+		drawn, d := c.jittered, c.lifetimeJitter
+		c.timerMu.Unlock()
+		if !drawn || d < 0 || d >= jitter {
+			t.Fatalf("client %d lifetime jitter = %v (drawn %t), want within [0, %v)", i, d, drawn, jitter)
+		}
+
+		readClose(t, conn, websocket.CloseGoingAway, "connection lifetime exceeded")
+		if age := time.Since(c.ConnectedAt()); age < lifetime+d {
+			t.Fatalf("client %d closed after %v, before its lifetime of %v", i, age, lifetime+d)
+		}
+	}
+}
+
+func TestIdleReconfigure(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    time.Duration
+		to      time.Duration
+		expires bool
+	}{
+		{"shortened", time.Hour, 30 * time.Millisecond, true},
+		{"disabled", 30 * time.Millisecond, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := New(WithIdleTimeout(tt.from))
+			conn := dial(t, serveNode(t, f), "u")
+			eventually(t, "the client", func() bool { return f.ClientLen() == 1 })
+
+			cfg := f.Config()
+			cfg.IdleTimeout = Duration(tt.to)
+			if err := f.Reconfigure(cfg); err != nil {
+				t.Fatal(err)
+			}
+			if tt.expires {
+				readClose(t, conn, websocket.CloseGoingAway, "idle timeout")
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+			if f.ClientLen() != 1 {
+				t.Fatal("client disconnected after the idle timeout was disabled")
+			}
+		})
+	}
+}
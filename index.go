@@ -0,0 +1,292 @@
+package fibril
+
+import (
+	"maps"
+	"reflect"
+	"sync"
+)
+
+// keyIndex maintains secondary indexes from declared client keys to the clients
+// holding each value, so lookups such as "all sessions of user 42" do not have
+// to scan every connected client.
+type keyIndex struct {
+	mu       sync.RWMutex
+	entries  map[any]map[any]map[string]*Client // key -> value -> client UUID -> client
+	sessions map[any]sessionRule                // Uniqueness policies enforced per key
+	limits   map[any]int                        // Maximum concurrent connections per key value
+}
+
+// indexed reports whether the given key was declared with WithIndexedKey.
+func (x *keyIndex) indexed(key any) bool {
+	if !isComparable(key) {
+		return false
+	}
+	_, ok := x.entries[key]
+	return ok
+}
+
+// add inserts a newly registered client under all of its indexed key values,
+// enforcing session policies atomically with the insertion. It returns the
+// existing clients that must be kicked along with the rule that kicked them,
+// or an error if a policy rejects the new client.
+func (x *keyIndex) add(c *Client) (map[*Client]sessionRule, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	var kicked map[*Client]sessionRule
+	for key := range x.entries {
+		value, ok := c.keys.Load(key)
+		if !ok {
+			continue
+		}
+		evict, err := x.admitLocked(c, key, value)
+		if err != nil {
+			return nil, err
+		}
+		for old, rule := range evict {
+			if kicked == nil {
+				kicked = make(map[*Client]sessionRule)
+			}
+			kicked[old] = rule
+		}
+	}
+
+	for old := range kicked {
+		x.removeLocked(old)
+	}
+
+	c.indexed = true
+	for key := range x.entries {
+		if value, ok := c.keys.Load(key); ok {
+			x.insert(key, value, c)
+		}
+	}
+	return kicked, nil
+}
+
+// admitLocked applies the per-key limit and session policy of key to a client about
+// to hold value. It returns the clients to kick, or an error if the client must be
+// rejected. The caller must hold x.mu.
+func (x *keyIndex) admitLocked(c *Client, key any, value any) (map[*Client]sessionRule, error) {
+	if !isComparable(value) {
+		return nil, nil
+	}
+	existing := x.entries[key][value]
+	if _, ok := existing[c.GetUUID()]; ok {
+		existing = maps.Clone(existing)
+		delete(existing, c.GetUUID())
+	}
+
+	if limit, ok := x.limits[key]; ok && len(existing) >= limit {
+		return nil, ErrTooManyConnectionsPerKey
+	}
+	rule, ok := x.sessions[key]
+	if !ok {
+		return nil, nil
+	}
+	admitted, evict := rule.admit(existing)
+	if !admitted {
+		return nil, &DisconnectError{Err: ErrSessionRejected, Reason: rule.closeReason}
+	}
+	var kicked map[*Client]sessionRule
+	for _, old := range evict {
+		if kicked == nil {
+			kicked = make(map[*Client]sessionRule)
+		}
+		kicked[old] = rule
+	}
+	return kicked, nil
+}
+
+// check reports whether a new client holding keys would currently be refused by a
+// per-key limit or a reject-newest session policy. It lets Handler refuse such
+// clients before the upgrade; add still enforces the policies atomically.
+func (x *keyIndex) check(keys map[any]any) error {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	for key, value := range keys {
+		if !isComparable(key) || !isComparable(value) {
+			continue
+		}
+		n := len(x.entries[key][value])
+		if limit, ok := x.limits[key]; ok && n >= limit {
+			return ErrTooManyConnectionsPerKey
+		}
+		if rule, ok := x.sessions[key]; ok && rule.policy == SessionRejectNewest && n >= rule.max {
+			return &DisconnectError{Err: ErrSessionRejected, Reason: rule.closeReason}
+		}
+	}
+	return nil
+}
+
+// remove deletes a client from all indexes.
+func (x *keyIndex) remove(c *Client) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.removeLocked(c)
+}
+
+// removeLocked deletes a client from all indexes. The caller must hold x.mu.
+func (x *keyIndex) removeLocked(c *Client) {
+	if !c.indexed {
+		return
+	}
+	c.indexed = false
+	for key := range x.entries {
+		if value, ok := c.keys.Load(key); ok {
+			x.delete(key, value, c)
+		}
+	}
+}
+
+// store sets an indexed key on the client and moves it to the new value's index entry.
+// For registered clients the key's limit and session policy are enforced atomically
+// with the change: it returns the clients to kick, or an error, in which case the key
+// is left unchanged. It also returns the previous value, if any.
+func (x *keyIndex) store(c *Client, key any, value any) (old any, loaded bool, kicked map[*Client]sessionRule, err error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if !c.indexed {
+		old, loaded = c.keys.Swap(key, value)
+		return old, loaded, nil, nil
+	}
+
+	old, loaded = c.keys.Load(key)
+	if !loaded || old != value {
+		if kicked, err = x.admitLocked(c, key, value); err != nil {
+			return old, loaded, nil, err
+		}
+		for k := range kicked {
+			x.removeLocked(k)
+		}
+	}
+
+	c.keys.Store(key, value)
+	if loaded {
+		x.delete(key, old, c)
+	}
+	x.insert(key, value, c)
+	return old, loaded, kicked, nil
+}
+
+// drop deletes an indexed key from the client and removes it from the index entry.
+// It returns the deleted value, if any.
+func (x *keyIndex) drop(c *Client, key any) (any, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	old, loaded := c.keys.LoadAndDelete(key)
+	if loaded && c.indexed {
+		x.delete(key, old, c)
+	}
+	return old, loaded
+}
+
+// lookup returns the clients whose key currently holds value.
+func (x *keyIndex) lookup(key any, value any) []*Client {
+	if !x.indexed(key) || !isComparable(value) {
+		return nil
+	}
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	clients := x.entries[key][value]
+	out := make([]*Client, 0, len(clients))
+	for _, c := range clients {
+		out = append(out, c)
+	}
+	return out
+}
+
+// count returns the number of clients whose key currently holds value.
+func (x *keyIndex) count(key any, value any) int {
+	if !x.indexed(key) || !isComparable(value) {
+		return 0
+	}
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	return len(x.entries[key][value])
+}
+
+// values returns the values of key held by at least one client.
+func (x *keyIndex) values(key any) []any {
+	if !x.indexed(key) {
+		return nil
+	}
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	out := make([]any, 0, len(x.entries[key]))
+	for value := range x.entries[key] {
+		out = append(out, value)
+	}
+	return out
+}
+
+// insert adds c under key/value. The caller must hold x.mu.
+func (x *keyIndex) insert(key any, value any, c *Client) {
+	if !isComparable(value) {
+		return
+	}
+	values := x.entries[key]
+	clients, ok := values[value]
+	if !ok {
+		clients = make(map[string]*Client)
+		values[value] = clients
+	}
+	clients[c.GetUUID()] = c
+}
+
+// delete removes c from key/value. The caller must hold x.mu.
+func (x *keyIndex) delete(key any, value any, c *Client) {
+	if !isComparable(value) {
+		return
+	}
+	values := x.entries[key]
+	if clients, ok := values[value]; ok {
+		delete(clients, c.GetUUID())
+		if len(clients) == 0 {
+			delete(values, value)
+		}
+	}
+}
+
+// isComparable reports whether v can be used as a map key.
+func isComparable(v any) bool {
+	return v != nil && reflect.ValueOf(v).Comparable()
+}
+
+// newKeyIndex creates an index for the given keys, session policies and per-key limits.
+// Keys with a session policy or a limit are always indexed. Non-comparable keys are ignored.
+func newKeyIndex(keys []any, sessions map[any]sessionRule, limits map[any]int) *keyIndex {
+	x := &keyIndex{
+		entries:  make(map[any]map[any]map[string]*Client),
+		sessions: make(map[any]sessionRule),
+		limits:   make(map[any]int),
+	}
+	for _, key := range keys {
+		if isComparable(key) {
+			x.entries[key] = make(map[any]map[string]*Client)
+		}
+	}
+	for key, rule := range sessions {
+		if isComparable(key) {
+			x.entries[key] = make(map[any]map[string]*Client)
+			x.sessions[key] = rule
+		}
+	}
+	for key, limit := range limits {
+		if isComparable(key) && limit > 0 {
+			x.entries[key] = make(map[any]map[string]*Client)
+			x.limits[key] = limit
+		}
+	}
+	return x
+}
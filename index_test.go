@@ -0,0 +1,58 @@
+package fibril
+
+import "testing"
+
+func TestKeyIndex(t *testing.T) {
+	f := New(WithIndexedKey("user", "room"))
+	clients := make(chan *Client, 1)
+	f.ConnectHandler(func(c *Client) { clients <- c })
+	conn := dial(t, serveNode(t, f), "alice")
+	alice := <-clients
+
+	lookup := func(key, value string, want int) {
+		t.Helper()
+		if got := f.ClientsByKey(key, value); len(got) != want {
+			t.Fatalf("ClientsByKey(%q, %q) = %d clients, want %d", key, value, len(got), want)
+		}
+	}
+
+	// Keys stored at the handshake are indexed on registration, later ones by StoreKey.
+	lookup("user", "alice", 1)
+	alice.StoreKey("room", "1")
+	lookup("room", "1", 1)
+	alice.StoreKey("room", "2")
+	lookup("room", "1", 0)
+	lookup("room", "2", 1)
+	alice.DeleteKey("room")
+	lookup("room", "2", 0)
+
+	if err := f.SendTextToKey("user", "alice", "send"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.BroadcastToKey("user", "alice", "broadcast"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"send", "broadcast"} {
+		if msg := readText(t, conn); msg != want {
+			t.Fatalf("received %q, want %q", msg, want)
+		}
+	}
+
+	for name, err := range map[string]error{
+		"SendTextToKey":   f.SendTextToKey("user", "bob", "x"),
+		"BroadcastToKey":  f.BroadcastToKey("user", "bob", "x"),
+		"DisconnectByKey": f.DisconnectByKey("bye", "user", "bob"),
+	} {
+		if err != ErrClientNotFound {
+			t.Errorf("%s without a match = %v, want ErrClientNotFound", name, err)
+		}
+	}
+	if err := f.BroadcastToKey("plan", "pro", "x"); err != ErrKeyNotIndexed {
+		t.Fatalf("BroadcastToKey on an undeclared key = %v, want ErrKeyNotIndexed", err)
+	}
+
+	_ = conn.Close()
+	eventually(t, "alice's removal from the index", func() bool {
+		return len(f.ClientsByKey("user", "alice")) == 0
+	})
+}
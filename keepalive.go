@@ -0,0 +1,123 @@
+package fibril
+
+import (
+	"bytes"
+	"github.com/gofiber/contrib/websocket"
+	"time"
+)
+
+// AppHeartbeat configures application-level heartbeats: data messages the server
+// sends and the client must answer, for clients such as browsers whose code cannot
+// see protocol pings and so cannot detect a half-open connection on their own.
+type AppHeartbeat struct {
+	// Interval between heartbeats.
+	// Optional. Default: 25 seconds
+	Interval time.Duration
+
+	// Timeout is how long the client has to reply before it is disconnected with
+	// a cause wrapping ErrHeartbeatTimeout.
+	// Optional. Default: 10 seconds
+	Timeout time.Duration
+
+	// Message is the heartbeat sent to the client.
+	// Optional. Default: {"type":"ping"}
+	Message []byte
+
+	// Binary sends Message as a binary frame instead of a text frame.
+	// Optional. Default: false
+	Binary bool
+
+	// Reply is the message the client answers with, compared after trimming
+	// surrounding whitespace. Replies are consumed and not passed to the message handlers.
+	// Optional. Default: {"type":"pong"}
+	Reply []byte
+
+	// IsReply overrides the comparison with Reply, e.g. to accept JSON with extra fields.
+	// Optional. Default: nil
+	IsReply func(msg []byte) bool
+}
+
+// withDefaults returns a copy of h with unset fields filled in.
+func (h AppHeartbeat) withDefaults() *AppHeartbeat {
+	if h.Interval <= 0 {
+		h.Interval = 25 * time.Second
+	}
+	if h.Timeout <= 0 {
+		h.Timeout = 10 * time.Second
+	}
+	if h.Message == nil {
+		h.Message = []byte(`{"type":"ping"}`)
+	}
+	if h.Reply == nil {
+		h.Reply = []byte(`{"type":"pong"}`)
+	}
+	return &h
+}
+
+// isReply reports whether msg answers a heartbeat.
+func (h *AppHeartbeat) isReply(msg []byte) bool {
+	if h.IsReply != nil {
+		return h.IsReply(msg)
+	}
+	return bytes.Equal(bytes.TrimSpace(msg), h.Reply)
+}
+
+// heartbeatReply handles an inbound message if it answers a heartbeat: the reply
+// counts as liveness and extends the read deadline like a pong.
+func (c *Client) heartbeatReply(msg []byte) bool {
+	if c.appHeartbeat == nil || !c.appHeartbeat.isReply(msg) {
+		return false
+	}
+	_ = c.conn.SetReadDeadline(c.readDeadline())
+	select {
+	case c.replies <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// heartbeatLoop sends application-level heartbeats and disconnects the client
+// if one is not answered in time. A heartbeat waits for queue space instead of being
+// dropped, so the timeout only runs for heartbeats that were queued.
+func (c *Client) heartbeatLoop() {
+	hb := c.appHeartbeat
+	ticker := time.NewTicker(hb.Interval)
+	defer ticker.Stop()
+
+	timer := time.NewTimer(hb.Timeout)
+	timer.Stop()
+
+	for {
+		select {
+		case <-c.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Discard a late reply to the previous heartbeat.
+		select {
+		case <-c.replies:
+		default:
+		}
+
+		t := websocket.TextMessage
+		if hb.Binary {
+			t = websocket.BinaryMessage
+		}
+		if err := c.enqueueContext(c.ctx, box{t: t, msg: hb.Message, priority: PriorityHigh, keepalive: true}); err != nil {
+			return
+		}
+
+		timer.Reset(hb.Timeout)
+		select {
+		case <-c.Done():
+			timer.Stop()
+			return
+		case <-c.replies:
+			timer.Stop()
+		case <-timer.C:
+			c.disconnect(&DisconnectError{Err: ErrHeartbeatTimeout, Reason: "heartbeat timeout"}, websocket.ClosePolicyViolation, "heartbeat timeout")
+			return
+		}
+	}
+}
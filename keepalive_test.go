@@ -0,0 +1,33 @@
+package fibril
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/gofiber/contrib/websocket"
+)
+
+func TestAppHeartbeat(t *testing.T) {
+	f := New(WithAppHeartbeat(AppHeartbeat{Interval: 20 * time.Millisecond, Timeout: 50 * time.Millisecond}))
+	var handled atomic.Int32
+	f.TextMessageHandler(func(*Client, string) { handled.Add(1) })
+	conn := dial(t, serveNode(t, f), "u")
+
+	// Answered heartbeats keep the connection open across several timeouts.
+	for i := 0; i < 5; i++ {
+		if msg := readText(t, conn); msg != `{"type":"ping"}` {
+			t.Fatalf("heartbeat %d = %q", i, msg)
+		}
+		if err := conn.WriteMessage(fasthttpws.TextMessage, []byte(" {\"type\":\"pong\"}\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := handled.Load(); n != 0 {
+		t.Fatalf("%d replies reached the message handler, want none", n)
+	}
+
+	// An unanswered heartbeat ends the connection.
+	readClose(t, conn, websocket.ClosePolicyViolation, "heartbeat timeout")
+}
@@ -0,0 +1,149 @@
+package fibril
+
+import (
+	"encoding/binary"
+	"github.com/gofiber/contrib/websocket"
+	"slices"
+	"sync"
+	"time"
+)
+
+// minLatencySamples is the number of round-trip time samples a client must have before
+// WithLatencyLimit can disconnect it. The first sample seeds the average directly, so
+// one slow pong right after connecting must not start the disconnect clock.
+const minLatencySamples = 3
+
+// RTTStats describes the round-trip time of a client measured from keep-alive pings.
+// The average and jitter are smoothed like TCP's SRTT and RTTVAR (RFC 6298).
+type RTTStats struct {
+	Last    time.Duration // Most recent sample
+	Avg     time.Duration // Exponentially weighted moving average
+	Jitter  time.Duration // Mean deviation of the samples from the average
+	Samples int           // Number of samples taken
+}
+
+// LatencyStats aggregates the round-trip times of the clients connected to a node.
+// The percentiles are taken over the clients' smoothed averages.
+type LatencyStats struct {
+	Clients int           // Number of clients with at least one sample
+	Avg     time.Duration // Mean of the clients' averages
+	P50     time.Duration // Median of the clients' averages
+	P95     time.Duration // 95th percentile of the clients' averages
+	Max     time.Duration // Highest of the clients' averages
+}
+
+// rttTracker accumulates round-trip time samples of a connection.
+type rttTracker struct {
+	mu        sync.Mutex
+	stats     RTTStats
+	overSince time.Time // Start of the current run of averages above the latency limit, zero if none
+}
+
+// pingPayload encodes the send time of a ping. Peers echo it in the pong.
+func pingPayload(now time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(now.UnixNano()))
+}
+
+// add records a sample and returns the updated statistics.
+func (t *rttTracker) add(rtt time.Duration) RTTStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := &t.stats
+	if s.Samples == 0 {
+		s.Avg = rtt
+		s.Jitter = rtt / 2
+	} else {
+		s.Jitter += (max(s.Avg-rtt, rtt-s.Avg) - s.Jitter) / 4
+		s.Avg += (rtt - s.Avg) / 8
+	}
+	s.Last = rtt
+	s.Samples++
+	return *s
+}
+
+// exceeded reports whether avg has stayed above limit for at least sustain.
+func (t *rttTracker) exceeded(avg, limit, sustain time.Duration, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if avg <= limit {
+		t.overSince = time.Time{}
+		return false
+	}
+	if t.overSince.IsZero() {
+		t.overSince = now
+	}
+	return now.Sub(t.overSince) >= sustain
+}
+
+// get returns the current statistics.
+func (t *rttTracker) get() RTTStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.stats
+}
+
+// pong records the round-trip time carried by a pong payload. Pongs that do not
+// echo one of our pings, such as unsolicited pongs, are ignored.
+func (c *Client) pong(payload string, now time.Time) {
+	if len(payload) != 8 {
+		return
+	}
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64([]byte(payload))))
+	rtt := now.Sub(sent)
+	if rtt < 0 || rtt > c.opt().pongWait {
+		return
+	}
+
+	stats := c.rtt.add(rtt)
+	if limit := c.opt().latencyLimit; limit > 0 && stats.Samples >= minLatencySamples &&
+		c.rtt.exceeded(stats.Avg, limit, c.opt().latencySustain, now) {
+		c.disconnect(&DisconnectError{Err: ErrHighLatency, Reason: "latency too high"}, websocket.ClosePolicyViolation, "latency too high")
+	}
+}
+
+// RTT returns the most recent round-trip time measured from a keep-alive ping,
+// or 0 if no pong has been received yet.
+func (c *Client) RTT() time.Duration {
+	return c.rtt.get().Last
+}
+
+// RTTStats returns the round-trip time statistics of the client.
+func (c *Client) RTTStats() RTTStats {
+	return c.rtt.get()
+}
+
+// latencyStats aggregates the round-trip times of the local clients that have been measured.
+func (h *Hub) latencyStats() LatencyStats {
+	var avgs []time.Duration
+	h.forEachClient(func(_ string, client *Client) {
+		if stats := client.rtt.get(); stats.Samples > 0 {
+			avgs = append(avgs, stats.Avg)
+		}
+	})
+	return summarizeLatency(avgs)
+}
+
+// summarizeLatency computes the mean, percentiles and maximum of the clients' averages.
+// It sorts avgs in place.
+func summarizeLatency(avgs []time.Duration) LatencyStats {
+	if len(avgs) == 0 {
+		return LatencyStats{}
+	}
+
+	slices.Sort(avgs)
+	var sum time.Duration
+	for _, avg := range avgs {
+		sum += avg
+	}
+	last := len(avgs) - 1
+	return LatencyStats{
+		Clients: len(avgs),
+		Avg:     sum / time.Duration(len(avgs)),
+		P50:     avgs[last/2],
+		P95:     avgs[last*95/100],
+		Max:     avgs[last],
+	}
+}
@@ -0,0 +1,125 @@
+package fibril
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+)
+
+func TestRTTSmoothing(t *testing.T) {
+	ms := time.Millisecond
+	steps := []struct {
+		rtt    time.Duration
+		avg    time.Duration
+		jitter time.Duration
+	}{
+		{100 * ms, 100 * ms, 50 * ms},                                                    // The first sample seeds the average, jitter is half of it
+		{200 * ms, 112500 * time.Microsecond, 62500 * time.Microsecond},                  // avg += (200-100)/8, jitter += (100-50)/4
+		{112500 * time.Microsecond, 112500 * time.Microsecond, 46875 * time.Microsecond}, // No deviation: jitter decays by a quarter
+	}
+
+	var tr rttTracker
+	for i, s := range steps {
+		got := tr.add(s.rtt)
+		if got.Last != s.rtt || got.Avg != s.avg || got.Jitter != s.jitter || got.Samples != i+1 {
+			t.Fatalf("sample %d: %+v, want avg %v, jitter %v", i, got, s.avg, s.jitter)
+		}
+	}
+}
+
+func TestLatencyExceeded(t *testing.T) {
+	const limit, sustain = 100 * time.Millisecond, time.Second
+	start := time.Unix(0, 0)
+
+	steps := []struct {
+		avg  time.Duration
+		at   time.Duration
+		want bool
+	}{
+		{50 * time.Millisecond, 0, false},
+		{150 * time.Millisecond, 0, false}, // Starts the run above the limit
+		{150 * time.Millisecond, 999 * time.Millisecond, false},
+		{100 * time.Millisecond, 1500 * time.Millisecond, false}, // At the limit: the run is reset
+		{150 * time.Millisecond, 2 * time.Second, false},
+		{150 * time.Millisecond, 3 * time.Second, true}, // Sustained for a second
+	}
+
+	var tr rttTracker
+	for i, s := range steps {
+		if got := tr.exceeded(s.avg, limit, sustain, start.Add(s.at)); got != s.want {
+			t.Fatalf("step %d: exceeded = %t, want %t", i, got, s.want)
+		}
+	}
+}
+
+func TestLatencyLimit(t *testing.T) {
+	f := New(WithLatencyLimit(50*time.Millisecond, 100*time.Millisecond))
+	clients := make(chan *Client, 1)
+	f.ConnectHandler(func(c *Client) { clients <- c })
+	conn := dial(t, serveNode(t, f), "u")
+	c := <-clients
+
+	base := time.Now()
+	slow := string(pingPayload(base))
+	pongs := []struct {
+		payload string
+		at      time.Duration
+		samples int
+	}{
+		{"hi", 200 * time.Millisecond, 0}, // Not one of our pings
+		{slow, -time.Millisecond, 0},      // Negative round trip
+		{slow, 2 * time.Minute, 0},        // Beyond the pong wait
+		{slow, 200 * time.Millisecond, 1}, // Slow samples below the minimum count are not judged
+		{slow, 210 * time.Millisecond, 2},
+		{slow, 220 * time.Millisecond, 3}, // Third sample: the sustain period starts
+		{slow, 300 * time.Millisecond, 4}, // Above the limit for 80ms
+	}
+	for i, p := range pongs {
+		c.pong(p.payload, base.Add(p.at))
+		if n := c.RTTStats().Samples; n != p.samples {
+			t.Fatalf("pong %d: %d samples, want %d", i, n, p.samples)
+		}
+		if !c.isOpen() {
+			t.Fatalf("pong %d disconnected the client before the sustain period", i)
+		}
+	}
+
+	c.pong(slow, base.Add(320*time.Millisecond)) // Above the limit for 100ms
+	readClose(t, conn, websocket.ClosePolicyViolation, "latency too high")
+}
+
+func TestSummarizeLatency(t *testing.T) {
+	ms := func(values ...int) []time.Duration {
+		out := make([]time.Duration, len(values))
+		for i, v := range values {
+			out[i] = time.Duration(v) * time.Millisecond
+		}
+		return out
+	}
+	hundred := make([]int, 100)
+	for i := range hundred {
+		hundred[i] = 100 - i // Unsorted: 100ms down to 1ms
+	}
+
+	tests := []struct {
+		name string
+		avgs []time.Duration
+		want LatencyStats
+	}{
+		{"none", nil, LatencyStats{}},
+		{"one", ms(40), LatencyStats{Clients: 1, Avg: 40 * time.Millisecond, P50: 40 * time.Millisecond,
+			P95: 40 * time.Millisecond, Max: 40 * time.Millisecond}},
+		{"even", ms(40, 10, 30, 20), LatencyStats{Clients: 4, Avg: 25 * time.Millisecond, P50: 20 * time.Millisecond,
+			P95: 30 * time.Millisecond, Max: 40 * time.Millisecond}},
+		{"hundred", ms(hundred...), LatencyStats{Clients: 100, Avg: 50500 * time.Microsecond, P50: 50 * time.Millisecond,
+			P95: 95 * time.Millisecond, Max: 100 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := summarizeLatency(tt.avgs); got != tt.want {
+				t.Fatalf("summarizeLatency = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package fibril
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// Key presence event types.
+const (
+	KeyPresenceJoin     = "join"      // A client holding the key value connected or set the key
+	KeyPresenceLeave    = "leave"     // A client holding the key value disconnected or changed the key
+	KeyPresenceNodeDown = "node_down" // A node stopped sending heartbeats; its clients are gone
+)
+
+// KeyPresenceEvent is published as JSON to the topic configured with WithKeyPresence
+// whenever a client holding the key joins or leaves on any node.
+type KeyPresenceEvent struct {
+	Type  string `json:"type"`            // One of the KeyPresence* types
+	Key   string `json:"key,omitempty"`   // Presence key, formatted with fmt.Sprint
+	Value string `json:"value,omitempty"` // Key value, formatted with fmt.Sprint
+	UUID  string `json:"uuid,omitempty"`  // Client that joined or left
+	Node  string `json:"node,omitempty"`  // Node of the client, or the node that went down
+}
+
+// formatKey formats a key or key value for directories, heartbeats and events.
+func formatKey(v any) string {
+	return fmt.Sprint(v)
+}
+
+// announceKey publishes a join or leave event for a client's key value if the key
+// is tracked with WithKeyPresence.
+func (h *Hub) announceKey(client *Client, typ string, key any, value any) {
+	topic, ok := h.options().keyPresence[key]
+	if !ok {
+		return
+	}
+	ev := KeyPresenceEvent{Type: typ, Key: formatKey(key), Value: formatKey(value), UUID: client.GetUUID()}
+	if h.cluster != nil {
+		ev.Node = h.cluster.node
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	h.broadcastPublish(topic, data)
+}
+
+// announceKeys publishes a join or leave event for every tracked key the client holds.
+func (h *Hub) announceKeys(client *Client, typ string) {
+	for key := range h.options().keyPresence {
+		if value, ok := client.keys.Load(key); ok {
+			h.announceKey(client, typ, key, value)
+		}
+	}
+}
+
+// announceNodeDown publishes a node_down event to local subscribers of every
+// presence topic. Each node detects expired peers on its own, so the event is
+// not forwarded.
+func (h *Hub) announceNodeDown(node string) {
+	for key, topic := range h.options().keyPresence {
+		data, err := json.Marshal(KeyPresenceEvent{Type: KeyPresenceNodeDown, Key: formatKey(key), Node: node})
+		if err != nil {
+			continue
+		}
+		_ = h.publish(topic, data)
+	}
+}
+
+// onlineLocal returns the formatted values of key held by local clients.
+func (h *Hub) onlineLocal(key any) []string {
+	values := h.index.values(key)
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		out = append(out, formatKey(v))
+	}
+	return out
+}
+
+// online returns the sorted, distinct values of key held by clients on all live nodes.
+func (h *Hub) online(key any) []string {
+	out := h.onlineLocal(key)
+	name := formatKey(key)
+	for _, s := range h.cluster.peers() {
+		out = append(out, s.Online[name]...)
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
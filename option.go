@@ -1,6 +1,10 @@
 package fibril
 
-import "time"
+import (
+	"net/netip"
+	"strings"
+	"time"
+)
 
 // handleErrorFunc defines a function type for handling errors from a client.
 type handleErrorFunc func(*Client, error)
@@ -13,6 +17,10 @@ type handleCloseFunc func(*Client, int, string) error
 // such as connection, disconnection, or pong responses.
 type handleClientFunc func(*Client)
 
+// handleIdleFunc defines a function type for handling clients that have been idle
+// for the given duration.
+type handleIdleFunc func(*Client, time.Duration)
+
 // option holds configuration settings for the WebSocket server behavior.
 type option struct {
 	shardCount           int                   // Number of shards for load distribution
@@ -29,6 +37,37 @@ type option struct {
 	connectHandler       handleClientFunc      // Handler triggered when a client connects
 	disconnectHandler    handleClientFunc      // Handler triggered when a client disconnects
 	pongHandler          handleClientFunc      // Handler triggered when a pong message is received
+	handshakeCapture     HandshakeCapture      // Request values captured for clients registered without Handler
+	trustedProxies       []netip.Prefix        // Proxies allowed to report the client IP via forwarding headers
+	indexedKeys          []any                 // Client keys maintained in secondary indexes
+	sessionRules         map[any]sessionRule   // Uniqueness policies per identity key
+	maxConnections       int                   // Maximum number of concurrent connections, 0 means unlimited
+	maxConnectionsPerIP  int                   // Maximum number of concurrent connections per client IP, 0 means unlimited
+	keyLimits            map[any]int           // Maximum number of concurrent connections per key value
+	acceptRate           float64               // Accepted connections per second, 0 means unlimited
+	acceptBurst          int                   // Number of connections accepted in a burst above acceptRate
+	writeBatchSize       int                   // Maximum number of queued messages written per writePump wakeup
+	binaryStreamHandler  handleStreamFunc      // Handler for binary messages larger than maxMessageSize
+	maxStreamSize        int64                 // Maximum size of a streamed binary message (in bytes)
+	streamTimeout        time.Duration         // Maximum duration to receive a streamed message, 0 means no extra limit
+	protocols            map[string]*Protocol  // Handler sets per negotiated subprotocol
+	protocolNames        []string              // Registered subprotocols in order of preference
+	recordConfig         *RecordConfig         // Traffic recording settings, nil disables recording
+	clusterNode          string                // Name of this node in the cluster
+	clusterDirectory     ClientDirectory       // Cluster-wide client locations, nil disables clustering
+	clusterTransport     ClusterTransport      // Node-to-node messaging
+	clusterTimeout       time.Duration         // Timeout of directory and transport calls
+	heartbeatInterval    time.Duration         // Interval between node heartbeats, 0 disables heartbeats
+	heartbeatTTL         time.Duration         // Time after the last heartbeat at which a node is considered dead
+	keyPresence          map[any]string        // Topics receiving join and leave events per presence key
+	presenceDiffs        bool                  // Publish join, leave and update diffs of topic presence to the topic
+	latencyLimit         time.Duration         // Average round-trip time above which clients are disconnected, 0 disables the limit
+	latencySustain       time.Duration         // How long the average must stay above latencyLimit before disconnecting
+	appHeartbeat         *AppHeartbeat         // Application-level heartbeat settings, nil if disabled
+	idleTimeout          time.Duration         // Time without application messages after which the idle handler runs, 0 disables it
+	idleHandler          handleIdleFunc        // Handler for idle clients, nil disconnects them
+	maxLifetime          time.Duration         // Maximum duration of a connection, 0 means unlimited
+	lifetimeJitter       time.Duration         // Random extra lifetime of up to this duration per connection
 }
 
 // OptFunc represents a functional option pattern for modifying the option struct.
@@ -86,6 +125,247 @@ func WithPingPeriod(pingPeriod time.Duration) OptFunc {
 	}
 }
 
+// WithHandshakeCapture sets which request values are copied into a client's Handshake
+// when the connection is registered through RegisterClient instead of Handler.
+func WithHandshakeCapture(capture HandshakeCapture) OptFunc {
+	return func(o *option) {
+		o.handshakeCapture = capture
+	}
+}
+
+// WithTrustedProxies sets the proxies (IP addresses or CIDR ranges) whose
+// X-Forwarded-For and Forwarded headers are used to resolve the real client IP.
+// Invalid entries are ignored.
+func WithTrustedProxies(proxies ...string) OptFunc {
+	return func(o *option) {
+		o.trustedProxies = o.trustedProxies[:0]
+		for _, p := range proxies {
+			if !strings.Contains(p, "/") {
+				if addr, err := netip.ParseAddr(p); err == nil {
+					o.trustedProxies = append(o.trustedProxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+				}
+				continue
+			}
+			if prefix, err := netip.ParsePrefix(p); err == nil {
+				o.trustedProxies = append(o.trustedProxies, prefix.Masked())
+			}
+		}
+	}
+}
+
+// WithIndexedKey declares client keys that are maintained in secondary indexes,
+// enabling lookups and sends by key value (e.g. all sessions of a user) without
+// scanning every client. Values stored under indexed keys must be comparable.
+func WithIndexedKey(keys ...any) OptFunc {
+	return func(o *option) {
+		o.indexedKeys = append(o.indexedKeys, keys...)
+	}
+}
+
+// WithSessionPolicy enforces a uniqueness policy for connections sharing the same value
+// of the given identity key. max is the number of concurrent connections allowed per
+// value (values below 1 default to 1) and closeReason is sent to the kicked or rejected
+// connection. The key is indexed automatically.
+func WithSessionPolicy(key any, policy SessionPolicy, max int, closeReason string) OptFunc {
+	return func(o *option) {
+		if max < 1 {
+			max = 1
+		}
+		if o.sessionRules == nil {
+			o.sessionRules = make(map[any]sessionRule)
+		}
+		o.sessionRules[key] = sessionRule{policy: policy, max: max, closeReason: closeReason}
+	}
+}
+
+// WithMaxConnections sets the maximum number of concurrent connections. 0 means unlimited.
+func WithMaxConnections(n int) OptFunc {
+	return func(o *option) {
+		o.maxConnections = max(n, 0)
+	}
+}
+
+// WithMaxConnectionsPerIP sets the maximum number of concurrent connections from a
+// single client IP (resolved through trusted proxies). 0 means unlimited.
+func WithMaxConnectionsPerIP(n int) OptFunc {
+	return func(o *option) {
+		o.maxConnectionsPerIP = max(n, 0)
+	}
+}
+
+// WithMaxConnectionsPerKey sets the maximum number of concurrent connections sharing
+// the same value of the given client key. The key is indexed automatically.
+func WithMaxConnectionsPerKey(key any, n int) OptFunc {
+	return func(o *option) {
+		if o.keyLimits == nil {
+			o.keyLimits = make(map[any]int)
+		}
+		o.keyLimits[key] = n
+	}
+}
+
+// WithAcceptRate limits how many new connections are accepted per second, allowing
+// bursts of up to burst connections. It protects the server from reconnect storms.
+// If burst is less than 1, it defaults to 1.
+func WithAcceptRate(perSecond float64, burst int) OptFunc {
+	return func(o *option) {
+		o.acceptRate = perSecond
+		o.acceptBurst = max(burst, 1)
+	}
+}
+
+// WithWriteBatching lets writePump drain up to size already-queued messages per wakeup
+// and write them back to back instead of returning to its event loop after each one.
+// It also enables JSON-array coalescing for clients that opt in with SetCoalescing.
+// If size is less than 1, it defaults to 1 (no batching).
+func WithWriteBatching(size int) OptFunc {
+	return func(o *option) {
+		o.writeBatchSize = max(size, 1)
+	}
+}
+
+// WithMaxStreamSize sets the maximum size of a binary message consumed by the binary
+// stream handler (in bytes). It has no effect unless a BinaryStreamHandler is set.
+func WithMaxStreamSize(size int64) OptFunc {
+	return func(o *option) {
+		o.maxStreamSize = size
+	}
+}
+
+// WithStreamTimeout sets the maximum duration allowed to receive a single streamed
+// binary message. Pongs do not extend the read deadline past it.
+func WithStreamTimeout(timeout time.Duration) OptFunc {
+	return func(o *option) {
+		o.streamTimeout = timeout
+	}
+}
+
+// WithProtocol registers a subprotocol with its handler set and codec. Registered
+// subprotocols are offered in registration order when upgrading through Handler, and
+// clients that negotiate one use its handlers instead of the ones set on Fibril.
+func WithProtocol(name string, protocol Protocol) OptFunc {
+	return func(o *option) {
+		if o.protocols == nil {
+			o.protocols = make(map[string]*Protocol)
+		}
+		if _, ok := o.protocols[name]; !ok {
+			o.protocolNames = append(o.protocolNames, name)
+		}
+		o.protocols[name] = &protocol
+	}
+}
+
+// WithRecorder enables traffic recording. Each selected connection's handshake and
+// inbound and outbound frames are written with timestamps to a JSONL file in
+// config.Dir, which cmd/fibril-replay can replay against a server. Credential
+// headers and cookies are redacted unless config allows them.
+func WithRecorder(config RecordConfig) OptFunc {
+	return func(o *option) {
+		o.recordConfig = &config
+	}
+}
+
+// WithCluster makes this instance a node of a cluster. Clients are recorded in the
+// directory under the given node name together with their indexed keys, and sends,
+// disconnects and lookups by UUID or indexed key reach clients on other nodes through
+// the transport. MemoryCluster provides both for nodes in the same process and
+// HTTPTransport connects nodes in separate processes. The directory may be nil, in
+// which case nodes only share counts and presence through heartbeats.
+func WithCluster(node string, directory ClientDirectory, transport ClusterTransport) OptFunc {
+	return func(o *option) {
+		o.clusterNode = node
+		o.clusterDirectory = directory
+		o.clusterTransport = transport
+	}
+}
+
+// WithClusterTimeout sets the timeout of cluster directory and transport calls.
+func WithClusterTimeout(timeout time.Duration) OptFunc {
+	return func(o *option) {
+		o.clusterTimeout = timeout
+	}
+}
+
+// WithHeartbeat makes the node broadcast its client count, topic subscriber counts and
+// presence to its peers every interval. Peers that have not sent a heartbeat within ttl
+// are considered dead: they no longer count towards cluster-wide totals, their clients
+// are purged from directories that support it, and a node_down presence event is
+// published. If ttl is not greater than interval, it defaults to three intervals.
+func WithHeartbeat(interval, ttl time.Duration) OptFunc {
+	return func(o *option) {
+		if ttl <= interval {
+			ttl = 3 * interval
+		}
+		o.heartbeatInterval = interval
+		o.heartbeatTTL = ttl
+	}
+}
+
+// WithKeyPresence tracks which values of a client key are online, e.g. which users,
+// and publishes a KeyPresenceEvent as JSON to topic on every node whenever a client
+// holding the key joins or leaves. The key is indexed automatically.
+func WithKeyPresence(key any, topic string) OptFunc {
+	return func(o *option) {
+		if o.keyPresence == nil {
+			o.keyPresence = make(map[any]string)
+		}
+		o.keyPresence[key] = topic
+		o.indexedKeys = append(o.indexedKeys, key)
+	}
+}
+
+// WithPresenceDiffs publishes a PresenceDiff as JSON to a topic, on every node of a
+// cluster, whenever a user's presence in it, set with Client.SetPresence or
+// Client.SubscribeWithPresence, joins, leaves or changes, so that subscribers can keep
+// their member list in sync without polling Fibril.Presence.
+func WithPresenceDiffs() OptFunc {
+	return func(o *option) {
+		o.presenceDiffs = true
+	}
+}
+
+// WithLatencyLimit disconnects clients whose average round-trip time, measured from
+// keep-alive pings, stays above limit for at least sustain. The disconnect cause
+// wraps ErrHighLatency. Samples are taken once per ping period (54 seconds by default)
+// and a client is only judged after its third sample, so sustain should span several
+// periods and a shorter WithPingPeriod makes the limit react sooner.
+func WithLatencyLimit(limit, sustain time.Duration) OptFunc {
+	return func(o *option) {
+		o.latencyLimit = limit
+		o.latencySustain = sustain
+	}
+}
+
+// WithAppHeartbeat makes the server send a heartbeat message to new connections
+// every interval and disconnect those that do not reply within the timeout, with a
+// cause wrapping ErrHeartbeatTimeout. Replies extend the read deadline like pongs.
+func WithAppHeartbeat(heartbeat AppHeartbeat) OptFunc {
+	return func(o *option) {
+		o.appHeartbeat = heartbeat.withDefaults()
+	}
+}
+
+// WithIdleTimeout sets how long a client may go without sending or receiving an
+// application message before the idle handler is called. Pings, pongs and
+// application-level heartbeats do not count as activity. Without an idle handler,
+// idle clients are disconnected with a cause wrapping ErrIdleTimeout.
+func WithIdleTimeout(timeout time.Duration) OptFunc {
+	return func(o *option) {
+		o.idleTimeout = timeout
+	}
+}
+
+// WithMaxConnectionLifetime closes connections with code 1001 (going away) once they
+// have been open for lifetime plus a random duration of up to jitter, so that clients
+// reconnect and their credentials are validated again. The jitter spreads the
+// reconnects of clients that connected together. The cause wraps ErrMaxLifetime.
+func WithMaxConnectionLifetime(lifetime, jitter time.Duration) OptFunc {
+	return func(o *option) {
+		o.maxLifetime = lifetime
+		o.lifetimeJitter = jitter
+	}
+}
+
 // defaultOption returns a new option instance with default configuration settings.
 func defaultOption() *option {
 	return &option{
@@ -96,6 +376,9 @@ func defaultOption() *option {
 		pongWait:             60 * time.Second,         // Default pong wait duration
 		pingPeriod:           54 * time.Second,         // Default ping period
 		disconnectDelayClose: 100 * time.Millisecond,   // Default delay before closing disconnected clients
+		writeBatchSize:       1,                        // Default to writing one message per wakeup
+		maxStreamSize:        64 << 20,                 // Default maximum streamed message size (64 MiB)
+		clusterTimeout:       5 * time.Second,          // Default timeout of cluster calls
 		textMessageHandler:   func(*Client, string) {}, // Default no-op handler for text messages
 		binaryMessageHandler: func(*Client, []byte) {}, // Default no-op handler for binary messages
 		errorHandler:         func(*Client, error) {},  // Default no-op handler for errors
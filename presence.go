@@ -0,0 +1,243 @@
+package fibril
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Topic presence diff types.
+const (
+	PresenceJoin   = "join"   // The user's first connection joined the topic
+	PresenceLeave  = "leave"  // The user's last connection left the topic
+	PresenceUpdate = "update" // The user's metadata changed
+)
+
+// PresenceMeta is the metadata a client attaches to its presence in a topic.
+// Connections sharing a UserID are reported as one user, e.g. several browser tabs.
+type PresenceMeta struct {
+	UserID string         `json:"user_id"`          // User the connection belongs to, the client UUID if empty
+	Name   string         `json:"name,omitempty"`   // Display name
+	Status string         `json:"status,omitempty"` // Status such as "online", "away" or "typing"
+	Extra  map[string]any `json:"extra,omitempty"`  // Application-specific fields
+}
+
+// PresenceEntry describes a user present in a topic.
+type PresenceEntry struct {
+	UserID      string       `json:"user_id"`     // User ID
+	Meta        PresenceMeta `json:"meta"`        // Metadata of the user's most recently updated connection
+	Connections int          `json:"connections"` // Number of the user's connections present in the topic
+}
+
+// PresenceDiff is published as JSON to a topic when WithPresenceDiffs is enabled.
+// Event is always "presence_diff" so clients can tell diffs from other messages.
+type PresenceDiff struct {
+	Event  string       `json:"event"`   // Always "presence_diff"
+	Topic  string       `json:"topic"`   // Topic the diff applies to
+	Type   string       `json:"type"`    // One of the Presence* diff types
+	UserID string       `json:"user_id"` // User that joined, left or was updated
+	Meta   PresenceMeta `json:"meta"`    // Current metadata, or the last metadata on leave
+}
+
+// presenceConn is the presence of one connection.
+type presenceConn struct {
+	meta PresenceMeta
+	seq  uint64 // Order of the last update, the highest wins within a user
+}
+
+// presenceTable tracks the presence of connections per topic and user.
+type presenceTable struct {
+	mu       sync.Mutex
+	topics   map[string]map[string]map[string]presenceConn // topic -> user ID -> client UUID -> presence
+	byClient map[string]map[string]string                  // client UUID -> topic -> user ID
+	seq      uint64
+}
+
+// newPresenceTable creates an empty presence table.
+func newPresenceTable() *presenceTable {
+	return &presenceTable{
+		topics:   make(map[string]map[string]map[string]presenceConn),
+		byClient: make(map[string]map[string]string),
+	}
+}
+
+// userMeta returns the metadata of the user's most recently updated connection.
+func userMeta(conns map[string]presenceConn) (PresenceMeta, bool) {
+	var (
+		best  presenceConn
+		found bool
+	)
+	for _, conn := range conns {
+		if !found || conn.seq > best.seq {
+			best, found = conn, true
+		}
+	}
+	return best.meta, found
+}
+
+// presenceChange compares a user's metadata before and after a change.
+func presenceChange(topic, user string, before PresenceMeta, had bool, after PresenceMeta, has bool) (PresenceDiff, bool) {
+	d := PresenceDiff{Event: "presence_diff", Topic: topic, UserID: user}
+	switch {
+	case !had && has:
+		d.Type, d.Meta = PresenceJoin, after
+	case had && !has:
+		d.Type, d.Meta = PresenceLeave, before
+	case had && has && !reflect.DeepEqual(before, after):
+		d.Type, d.Meta = PresenceUpdate, after
+	default:
+		return d, false
+	}
+	return d, true
+}
+
+// set records or updates the presence of a client in topic.
+func (t *presenceTable) set(uuid, topic string, meta PresenceMeta) []PresenceDiff {
+	if meta.UserID == "" {
+		meta.UserID = uuid
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var diffs []PresenceDiff
+	if user, ok := t.byClient[uuid][topic]; ok && user != meta.UserID {
+		// The connection switched users: leave as the old one first.
+		diffs = append(diffs, t.removeLocked(uuid, topic)...)
+	}
+
+	users, ok := t.topics[topic]
+	if !ok {
+		users = make(map[string]map[string]presenceConn)
+		t.topics[topic] = users
+	}
+	conns, ok := users[meta.UserID]
+	if !ok {
+		conns = make(map[string]presenceConn)
+		users[meta.UserID] = conns
+	}
+	before, had := userMeta(conns)
+
+	t.seq++
+	conns[uuid] = presenceConn{meta: meta, seq: t.seq}
+	topics, ok := t.byClient[uuid]
+	if !ok {
+		topics = make(map[string]string)
+		t.byClient[uuid] = topics
+	}
+	topics[topic] = meta.UserID
+
+	after, has := userMeta(conns)
+	if d, ok := presenceChange(topic, meta.UserID, before, had, after, has); ok {
+		diffs = append(diffs, d)
+	}
+	return diffs
+}
+
+// remove clears the presence of a client in topic.
+func (t *presenceTable) remove(uuid, topic string) []PresenceDiff {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.removeLocked(uuid, topic)
+}
+
+// removeClient clears the presence of a client in every topic.
+func (t *presenceTable) removeClient(uuid string) []PresenceDiff {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var diffs []PresenceDiff
+	for topic := range t.byClient[uuid] {
+		diffs = append(diffs, t.removeLocked(uuid, topic)...)
+	}
+	return diffs
+}
+
+// removeLocked clears the presence of a client in topic. t.mu must be held.
+func (t *presenceTable) removeLocked(uuid, topic string) []PresenceDiff {
+	user, ok := t.byClient[uuid][topic]
+	if !ok {
+		return nil
+	}
+	delete(t.byClient[uuid], topic)
+	if len(t.byClient[uuid]) == 0 {
+		delete(t.byClient, uuid)
+	}
+
+	conns := t.topics[topic][user]
+	before, had := userMeta(conns)
+	delete(conns, uuid)
+	after, has := userMeta(conns)
+	if len(conns) == 0 {
+		delete(t.topics[topic], user)
+		if len(t.topics[topic]) == 0 {
+			delete(t.topics, topic)
+		}
+	}
+
+	if d, ok := presenceChange(topic, user, before, had, after, has); ok {
+		return []PresenceDiff{d}
+	}
+	return nil
+}
+
+// list returns the users present in topic, sorted by user ID.
+func (t *presenceTable) list(topic string) []PresenceEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entries := make([]PresenceEntry, 0, len(t.topics[topic]))
+	for user, conns := range t.topics[topic] {
+		meta, _ := userMeta(conns)
+		entries = append(entries, PresenceEntry{UserID: user, Meta: meta, Connections: len(conns)})
+	}
+	slices.SortFunc(entries, func(a, b PresenceEntry) int {
+		return strings.Compare(a.UserID, b.UserID)
+	})
+	return entries
+}
+
+// SubscribeWithPresence subscribes the client to a topic like SubscribeText and attaches
+// presence metadata to it, so the presence lasts as long as the subscription.
+func (c *Client) SubscribeWithPresence(topic string, meta PresenceMeta) {
+	c.SubscribeText(topic)
+	c.SetPresence(topic, meta)
+}
+
+// SetPresence attaches presence metadata to the client in a topic, or updates it.
+// A client that is not subscribed to the topic is subscribed with SubscribeText first,
+// so the presence never outlives the subscription. The presence is cleared by
+// ClearPresence, Unsubscribe or when the client disconnects.
+func (c *Client) SetPresence(topic string, meta PresenceMeta) {
+	if _, ok := c.topics.Load(topic); !ok {
+		c.SubscribeText(topic)
+	}
+	c.hub.publishPresence(c.hub.topicPresence.set(c.GetUUID(), topic, meta))
+	if c.State() >= StateClosing {
+		// Lost a race with destroy: do not leave a stale entry behind.
+		c.hub.publishPresence(c.hub.topicPresence.removeClient(c.GetUUID()))
+	}
+}
+
+// ClearPresence removes the client's presence from a topic.
+func (c *Client) ClearPresence(topic string) {
+	c.hub.publishPresence(c.hub.topicPresence.remove(c.GetUUID(), topic))
+}
+
+// publishPresence publishes diffs to their topics on every node if WithPresenceDiffs
+// is enabled.
+func (h *Hub) publishPresence(diffs []PresenceDiff) {
+	if !h.options().presenceDiffs {
+		return
+	}
+	for _, d := range diffs {
+		data, err := json.Marshal(d)
+		if err != nil {
+			continue
+		}
+		h.broadcastPublish(d.Topic, data)
+	}
+}
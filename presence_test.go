@@ -0,0 +1,91 @@
+package fibril
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestPresenceTable(t *testing.T) {
+	table := newPresenceTable()
+	online := PresenceMeta{UserID: "alice", Status: "online"}
+	away := PresenceMeta{UserID: "alice", Status: "away"}
+
+	steps := []struct {
+		name  string
+		apply func() []PresenceDiff
+		diff  string // Type of the expected diff, "" for none
+		meta  PresenceMeta
+		conns int // Connections of alice listed afterwards
+	}{
+		{"first tab joins", func() []PresenceDiff { return table.set("tab1", "room", online) }, PresenceJoin, online, 1},
+		{"second tab joins", func() []PresenceDiff { return table.set("tab2", "room", online) }, "", online, 2},
+		{"second tab goes away", func() []PresenceDiff { return table.set("tab2", "room", away) }, PresenceUpdate, away, 2},
+		{"first tab leaves", func() []PresenceDiff { return table.remove("tab1", "room") }, "", away, 1},
+		{"second tab leaves", func() []PresenceDiff { return table.removeClient("tab2") }, PresenceLeave, away, 0},
+	}
+	for _, s := range steps {
+		diffs := s.apply()
+		switch {
+		case s.diff == "" && len(diffs) != 0:
+			t.Fatalf("%s: diffs = %+v, want none", s.name, diffs)
+		case s.diff != "" && (len(diffs) != 1 || diffs[0].Type != s.diff || diffs[0].Meta.Status != s.meta.Status):
+			t.Fatalf("%s: diffs = %+v, want one %s with status %q", s.name, diffs, s.diff, s.meta.Status)
+		}
+
+		list := table.list("room")
+		if s.conns == 0 {
+			if len(list) != 0 {
+				t.Fatalf("%s: list = %+v, want empty", s.name, list)
+			}
+			continue
+		}
+		if len(list) != 1 || list[0].UserID != "alice" || list[0].Connections != s.conns || list[0].Meta.Status != s.meta.Status {
+			t.Fatalf("%s: list = %+v, want alice with %d connections and status %q", s.name, list, s.conns, s.meta.Status)
+		}
+	}
+}
+
+func TestPresenceSwitchUser(t *testing.T) {
+	table := newPresenceTable()
+	table.set("tab1", "room", PresenceMeta{UserID: "alice"})
+
+	diffs := table.set("tab1", "room", PresenceMeta{UserID: "bob"})
+	if len(diffs) != 2 || diffs[0].Type != PresenceLeave || diffs[0].UserID != "alice" ||
+		diffs[1].Type != PresenceJoin || diffs[1].UserID != "bob" {
+		t.Fatalf("diffs = %+v, want alice leaving and bob joining", diffs)
+	}
+	// A connection without a user ID is listed under its UUID.
+	table.set("tab2", "room", PresenceMeta{})
+	var users []string
+	for _, e := range table.list("room") {
+		users = append(users, e.UserID)
+	}
+	if !slices.Equal(users, []string{"bob", "tab2"}) {
+		t.Fatalf("users = %v, want [bob tab2]", users)
+	}
+}
+
+func TestSetPresenceSubscribes(t *testing.T) {
+	const topic = "room:1"
+	f := New(WithPresenceDiffs())
+	clients := make(chan *Client, 1)
+	f.ConnectHandler(func(c *Client) { clients <- c })
+	conn := dial(t, serveNode(t, f), "alice")
+	c := <-clients
+
+	c.SetPresence(topic, PresenceMeta{UserID: "alice"})
+	if !slices.Contains(c.Subscriptions(), topic) {
+		t.Fatalf("subscriptions = %v, want %s", c.Subscriptions(), topic)
+	}
+	// The client receives its own join through the subscription.
+	var diff PresenceDiff
+	if err := json.Unmarshal([]byte(readText(t, conn)), &diff); err != nil || diff.Type != PresenceJoin {
+		t.Fatalf("received %+v, %v, want alice's join", diff, err)
+	}
+
+	c.Unsubscribe(topic)
+	if members := f.Presence(topic); len(members) != 0 {
+		t.Fatalf("presence = %+v after Unsubscribe, want none", members)
+	}
+}
@@ -0,0 +1,144 @@
+package fibril
+
+import (
+	"context"
+	"github.com/gofiber/contrib/websocket"
+)
+
+// Priority is the delivery priority of an outgoing message. writePump always drains
+// higher-priority queues before lower ones.
+type Priority int
+
+const (
+	PriorityLow    Priority = -1 // Bulk traffic that may wait behind everything else
+	PriorityNormal Priority = 0  // Default priority for all sends and broadcasts
+	PriorityHigh   Priority = 1  // Important messages that must overtake queued normal traffic
+)
+
+// priorityLevels is the number of data queues per client.
+const priorityLevels = 3
+
+// controlQueueSize is the capacity of the control lane, which carries close frames
+// and is kept separate so they are accepted even when the data queues are full.
+const controlQueueSize = 4
+
+// queueIndex maps a priority to its data queue, clamping unknown values.
+func (p Priority) queueIndex() int {
+	switch {
+	case p >= PriorityHigh:
+		return 0
+	case p <= PriorityLow:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// BroadcastOption customizes a broadcast message.
+type BroadcastOption func(*box)
+
+// WithBroadcastPriority sets the delivery priority of a broadcast message.
+func WithBroadcastPriority(p Priority) BroadcastOption {
+	return func(b *box) {
+		b.priority = p
+	}
+}
+
+// queueFor returns the control lane for close frames and the data queue of the
+// message priority otherwise.
+func (c *Client) queueFor(message box) chan box {
+	if message.t == websocket.CloseMessage {
+		return c.control
+	}
+	return c.queues[message.priority.queueIndex()]
+}
+
+// enqueue places a message on the control lane or on the data queue of its priority.
+// It reports false if the queue is full.
+func (c *Client) enqueue(message box) bool {
+	select {
+	case c.queueFor(message) <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+// enqueueContext places a message on its queue, waiting for space until ctx is done
+// or the client disconnects. A message queued while the client was closing is never
+// written, so it reports ErrClientClosed for it as well.
+func (c *Client) enqueueContext(ctx context.Context, message box) error {
+	if !c.isOpen() {
+		return ErrClientClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	select {
+	case c.queueFor(message) <- message:
+		if !c.isOpen() {
+			return ErrClientClosed
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.Done():
+		return ErrClientClosed
+	}
+}
+
+// next returns the highest-priority queued message without blocking.
+// A close frame overtakes all data except the PriorityHigh messages queued before it.
+func (c *Client) next() (box, bool) {
+	if c.pendingClose == nil {
+		select {
+		case b := <-c.control:
+			return c.holdClose(b), true
+		default:
+		}
+	}
+	if c.pendingClose != nil {
+		if c.closeAfter > 0 {
+			c.closeAfter--
+			return <-c.queues[0], true
+		}
+		b := *c.pendingClose
+		c.pendingClose = nil
+		return b, true
+	}
+	for _, queue := range c.queues {
+		select {
+		case b := <-queue:
+			return b, true
+		default:
+		}
+	}
+	return box{}, false
+}
+
+// holdClose holds back a close frame taken from the control lane until the PriorityHigh
+// messages already queued are written, and returns the next message to write.
+func (c *Client) holdClose(closeMsg box) box {
+	c.pendingClose, c.closeAfter = &closeMsg, len(c.queues[0])
+	b, _ := c.next()
+	return b
+}
+
+// queueLen returns the number of data messages waiting across all priority queues.
+func (c *Client) queueLen() int {
+	n := 0
+	for _, queue := range c.queues {
+		n += len(queue)
+	}
+	return n
+}
+
+// newQueues creates the per-priority data queues of a client.
+func newQueues(size int) [priorityLevels]chan box {
+	var queues [priorityLevels]chan box
+	for i := range queues {
+		queues[i] = make(chan box, size)
+	}
+	return queues
+}
@@ -0,0 +1,56 @@
+package fibril
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/gofiber/contrib/websocket"
+)
+
+func TestPriorityOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		disconnect bool
+		want       []string
+	}{
+		{"queues", false, []string{"high 1", "high 2", "normal 1", "normal 2", "low 1"}},
+		// The close frame waits for the PriorityHigh messages queued before it, but
+		// overtakes normal and low traffic.
+		{"close", true, []string{"high 1", "high 2"}},
+	}
+	for _, tt := range tests {
+		for _, batch := range []int{1, 4} {
+			t.Run(fmt.Sprintf("%s/batch=%d", tt.name, batch), func(t *testing.T) {
+				f := New(WithWriteBatching(batch))
+				// Messages sent from the connect handler are queued before the write pump starts.
+				f.ConnectHandler(func(c *Client) {
+					_ = c.SendTextPriority("low 1", PriorityLow)
+					_ = c.SendText("normal 1")
+					_ = c.SendTextPriority("high 1", PriorityHigh)
+					_ = c.SendTextPriority("normal 2", PriorityNormal)
+					_ = c.SendTextPriority("high 2", PriorityHigh)
+					if tt.disconnect {
+						c.Disconnect("bye")
+					}
+				})
+				conn := dial(t, serveNode(t, f), "u")
+
+				for i, want := range tt.want {
+					if msg := readText(t, conn); msg != want {
+						t.Fatalf("message %d = %q, want %q", i, msg, want)
+					}
+				}
+				if !tt.disconnect {
+					return
+				}
+				_, _, err := conn.ReadMessage()
+				var closeErr *fasthttpws.CloseError
+				if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseNormalClosure || closeErr.Text != "bye" {
+					t.Fatalf("after the high-priority messages: %v, want close 1000 \"bye\"", err)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package fibril
+
+import (
+	"encoding/json"
+	"github.com/gofiber/contrib/websocket"
+)
+
+// Codec encodes and decodes application values for a subprotocol.
+type Codec interface {
+	Marshal(v any) ([]byte, error)      // Encodes v into a message payload
+	Unmarshal(data []byte, v any) error // Decodes a message payload into v
+	Binary() bool                       // Whether encoded values are sent as binary frames
+}
+
+// JSONCodec encodes values as JSON text frames.
+type JSONCodec struct{}
+
+// Marshal encodes v as JSON.
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes JSON data into v.
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// Binary reports false: JSON is sent as text frames.
+func (JSONCodec) Binary() bool {
+	return false
+}
+
+// Protocol is the handler set and codec bound to clients that negotiated a subprotocol.
+// Nil handlers fall back to the handlers set on Fibril.
+type Protocol struct {
+	Codec                Codec                 // Codec used by Client.Send and Client.Decode, defaults to JSONCodec
+	TextMessageHandler   func(*Client, string) // Handler for text messages
+	BinaryMessageHandler func(*Client, []byte) // Handler for binary messages
+	ConnectHandler       func(*Client)         // Handler triggered when a client connects
+	DisconnectHandler    func(*Client)         // Handler triggered when a client disconnects
+}
+
+// Protocol returns the subprotocol negotiated by the client, or an empty string.
+func (c *Client) Protocol() string {
+	return c.handshake.Subprotocol()
+}
+
+// Codec returns the codec bound to the client's subprotocol, or JSONCodec if none.
+func (c *Client) Codec() Codec {
+	if c.protocol != nil && c.protocol.Codec != nil {
+		return c.protocol.Codec
+	}
+	return JSONCodec{}
+}
+
+// Send encodes v with the client's codec and sends it as a text or binary message.
+func (c *Client) Send(v any) error {
+	codec := c.Codec()
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if codec.Binary() {
+		return c.SendBinary(data)
+	}
+	return c.SendText(string(data))
+}
+
+// Decode decodes a received message payload into v with the client's codec.
+func (c *Client) Decode(data []byte, v any) error {
+	return c.Codec().Unmarshal(data, v)
+}
+
+// onText dispatches a text message to the protocol or default handler.
+func (c *Client) onText(msg string) {
+	if c.protocol != nil && c.protocol.TextMessageHandler != nil {
+		c.protocol.TextMessageHandler(c, msg)
+		return
+	}
+	c.opt().textMessageHandler(c, msg)
+}
+
+// onBinary dispatches a binary message to the protocol or default handler.
+func (c *Client) onBinary(msg []byte) {
+	if c.protocol != nil && c.protocol.BinaryMessageHandler != nil {
+		c.protocol.BinaryMessageHandler(c, msg)
+		return
+	}
+	c.opt().binaryMessageHandler(c, msg)
+}
+
+// onConnect dispatches the connect event to the protocol or default handler.
+func (c *Client) onConnect() {
+	if c.protocol != nil && c.protocol.ConnectHandler != nil {
+		c.protocol.ConnectHandler(c)
+		return
+	}
+	c.opt().connectHandler(c)
+}
+
+// onDisconnect dispatches the disconnect event to the protocol or default handler.
+func (c *Client) onDisconnect() {
+	if c.protocol != nil && c.protocol.DisconnectHandler != nil {
+		c.protocol.DisconnectHandler(c)
+		return
+	}
+	c.opt().disconnectHandler(c)
+}
+
+// withSubprotocols returns the upgrade config with the registered subprotocols offered
+// for negotiation, unless the caller already listed subprotocols.
+func (o *option) withSubprotocols(config []websocket.Config) []websocket.Config {
+	if len(o.protocolNames) == 0 {
+		return config
+	}
+
+	var cfg websocket.Config
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	if len(cfg.Subprotocols) == 0 {
+		cfg.Subprotocols = o.protocolNames
+	}
+	return []websocket.Config{cfg}
+}
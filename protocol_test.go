@@ -0,0 +1,86 @@
+package fibril
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	fasthttpws "github.com/fasthttp/websocket"
+)
+
+// binaryJSON is a codec that sends JSON in binary frames.
+type binaryJSON struct{ JSONCodec }
+
+func (binaryJSON) Binary() bool { return true }
+
+func TestProtocolNegotiation(t *testing.T) {
+	type event struct {
+		Name string `json:"name"`
+	}
+	handled := make(chan string, 4)
+	f := New(
+		WithProtocol("chat.v1", Protocol{
+			TextMessageHandler: func(c *Client, msg string) { handled <- "v1 " + msg },
+			ConnectHandler:     func(c *Client) { _ = c.Send(event{Name: "welcome"}) },
+		}),
+		WithProtocol("chat.v2", Protocol{
+			Codec: binaryJSON{},
+			BinaryMessageHandler: func(c *Client, msg []byte) {
+				var e event
+				if err := c.Decode(msg, &e); err != nil {
+					t.Error(err)
+				}
+				handled <- "v2 " + e.Name
+			},
+			ConnectHandler: func(c *Client) { _ = c.Send(event{Name: "welcome"}) },
+		}),
+	)
+	f.TextMessageHandler(func(c *Client, msg string) { handled <- "default(" + c.Protocol() + ") " + msg })
+	url := serveNode(t, f)
+
+	tests := []struct {
+		name    string
+		offer   []string
+		want    string // negotiated subprotocol
+		welcome int    // frame type of the welcome message, 0 if none
+		typ     int    // frame type of the message sent by the test
+		msg     string // payload of the message sent by the test
+		handled string // handler output for the message
+	}{
+		{"v1", []string{"chat.v1"}, "chat.v1", fasthttpws.TextMessage, fasthttpws.TextMessage, "hi", "v1 hi"},
+		{"server preference", []string{"chat.v2", "chat.v1"}, "chat.v1", fasthttpws.TextMessage, fasthttpws.TextMessage, "hi", "v1 hi"},
+		{"v2", []string{"chat.v2"}, "chat.v2", fasthttpws.BinaryMessage, fasthttpws.BinaryMessage, `{"name":"ping"}`, "v2 ping"},
+		{"fallback handler", []string{"chat.v2"}, "chat.v2", fasthttpws.BinaryMessage, fasthttpws.TextMessage, "hi", "default(chat.v2) hi"},
+		{"unknown", []string{"chat.v9"}, "", 0, fasthttpws.TextMessage, "hi", "default() hi"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := fasthttpws.Dialer{Subprotocols: tt.offer}
+			conn, _, err := dialer.Dial(url+"?user="+tt.name, http.Header{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if got := conn.Subprotocol(); got != tt.want {
+				t.Fatalf("negotiated %q, want %q", got, tt.want)
+			}
+			if tt.welcome != 0 {
+				typ, msg := readMessage(t, conn)
+				if typ != tt.welcome || msg != `{"name":"welcome"}` {
+					t.Fatalf("welcome = %d %q, want %d %q", typ, msg, tt.welcome, `{"name":"welcome"}`)
+				}
+			}
+			if err := conn.WriteMessage(tt.typ, []byte(tt.msg)); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case got := <-handled:
+				if got != tt.handled {
+					t.Fatalf("handled %q, want %q", got, tt.handled)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("message was not handled")
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package fibril
+
+import (
+	"context"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// clone returns a copy of the options that shares no mutable state with o.
+func (o *option) clone() *option {
+	c := *o
+	c.trustedProxies = slices.Clone(o.trustedProxies)
+	c.indexedKeys = slices.Clone(o.indexedKeys)
+	c.sessionRules = maps.Clone(o.sessionRules)
+	c.keyLimits = maps.Clone(o.keyLimits)
+	c.protocols = maps.Clone(o.protocols)
+	c.protocolNames = slices.Clone(o.protocolNames)
+	c.keyPresence = maps.Clone(o.keyPresence)
+	return &c
+}
+
+// Reconfigure validates cfg and atomically applies it, followed by args, on top of the
+// current options. Handlers and tunables take effect immediately: connected clients use
+// the new handlers, write wait, pong wait, write batching and message size limits right
+// away, re-arm their idle and lifetime timers, and restart their ping ticker with a new
+// ping period. Connection limits and the message buffer size apply to new connections.
+//
+// The shard count, indexed keys, session policies, per-key limits, subprotocols and
+// cluster membership are fixed when the Fibril instance is created; changes to them
+// are ignored.
+func (f *Fibril) Reconfigure(cfg Config, args ...OptFunc) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	f.hub.updateOptions(func(o *option) {
+		fixed := o.clone()
+		for _, optFunc := range append(cfg.options(), args...) {
+			optFunc(o)
+		}
+		o.shardCount = fixed.shardCount
+		o.indexedKeys = fixed.indexedKeys
+		o.sessionRules = fixed.sessionRules
+		o.keyLimits = fixed.keyLimits
+		o.protocols = fixed.protocols
+		o.protocolNames = fixed.protocolNames
+		o.clusterNode = fixed.clusterNode
+		o.clusterDirectory = fixed.clusterDirectory
+		o.clusterTransport = fixed.clusterTransport
+		o.heartbeatInterval = fixed.heartbeatInterval
+		o.heartbeatTTL = fixed.heartbeatTTL
+		o.keyPresence = fixed.keyPresence
+	})
+	f.hub.forEachClient(func(_ string, c *Client) {
+		c.armTimers()
+		c.optionsChanged()
+	})
+	return nil
+}
+
+// Config returns the current settings, including those set through OptFuncs or
+// Reconfigure. Pass it, modified, to Reconfigure to change individual settings.
+func (f *Fibril) Config() Config {
+	return configFromOption(f.hub.options())
+}
+
+// ReloadConfig configures WatchConfig.
+type ReloadConfig struct {
+	// Path is the YAML or JSON configuration file loaded with LoadConfig.
+	Path string
+
+	// EnvPrefix, if set, re-applies environment overrides with LoadEnv after each load.
+	// Optional. Default: ""
+	EnvPrefix string
+
+	// Interval is how often the file's modification time is checked.
+	// Optional. Default: 0, which disables polling.
+	Interval time.Duration
+
+	// SIGHUP reloads the configuration when the process receives SIGHUP.
+	// Optional. Default: false
+	SIGHUP bool
+
+	// OnReload is called after every reload attempt with the loaded configuration and
+	// the load, validation or apply error, if any. A failed reload keeps the current settings.
+	// Optional. Default: nil
+	OnReload func(Config, error)
+}
+
+// WatchConfig reloads the configuration file described by rc and applies it with
+// Reconfigure whenever the file changes or SIGHUP is received, until ctx is done.
+// Each reload starts from the settings in effect when WatchConfig was called, so
+// settings missing from the file keep those values rather than the defaults.
+// It returns immediately; reloading happens in the background.
+func (f *Fibril) WatchConfig(ctx context.Context, rc ReloadConfig) {
+	base := f.Config()
+
+	var hup chan os.Signal
+	if rc.SIGHUP {
+		hup = make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+	}
+
+	var poll <-chan time.Time
+	var ticker *time.Ticker
+	if rc.Interval > 0 {
+		ticker = time.NewTicker(rc.Interval)
+		poll = ticker.C
+	}
+
+	modTime := func() time.Time {
+		if info, err := os.Stat(rc.Path); err == nil {
+			return info.ModTime()
+		}
+		return time.Time{}
+	}
+	last := modTime()
+
+	reload := func() {
+		cfg := base
+		cfg.TrustedProxies = slices.Clone(base.TrustedProxies)
+		err := cfg.Load(rc.Path)
+		if err == nil && rc.EnvPrefix != "" {
+			err = cfg.LoadEnv(rc.EnvPrefix)
+		}
+		if err == nil {
+			err = f.Reconfigure(cfg)
+		}
+		if rc.OnReload != nil {
+			rc.OnReload(cfg, err)
+		}
+	}
+
+	go func() {
+		defer func() {
+			if hup != nil {
+				signal.Stop(hup)
+			}
+			if ticker != nil {
+				ticker.Stop()
+			}
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				last = modTime()
+				reload()
+			case <-poll:
+				if t := modTime(); !t.Equal(last) {
+					last = t
+					reload()
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,91 @@
+package fibril
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+)
+
+func TestReconfigureOptions(t *testing.T) {
+	f := New(WithShardCount(4), WithIndexedKey("user"))
+
+	cfg := f.Config()
+	cfg.ShardCount = 8
+	cfg.MaxMessageSize = 4096
+	cfg.IdleTimeout = Duration(time.Minute)
+	if err := f.Reconfigure(cfg, WithIndexedKey("room"), WithWriteBatching(4)); err != nil {
+		t.Fatal(err)
+	}
+
+	got := f.Config()
+	if got.MaxMessageSize != 4096 || got.IdleTimeout != Duration(time.Minute) || got.WriteBatchSize != 4 {
+		t.Fatalf("Config after Reconfigure = %+v, want the new tunables", got)
+	}
+	if got.ShardCount != 4 {
+		t.Fatalf("shard count = %d after Reconfigure, want the fixed 4", got.ShardCount)
+	}
+	if keys := f.hub.options().indexedKeys; !slices.Equal(keys, []any{"user"}) {
+		t.Fatalf("indexed keys = %v after Reconfigure, want the fixed [user]", keys)
+	}
+
+	// An invalid configuration is rejected as a whole.
+	bad := got
+	bad.MaxMessageSize = 1024
+	bad.PingPeriod = bad.PongWait
+	if err := f.Reconfigure(bad); err == nil {
+		t.Fatal("Reconfigure accepted ping_period equal to pong_wait")
+	}
+	if f.Config().MaxMessageSize != 4096 {
+		t.Fatal("a rejected Reconfigure changed the options")
+	}
+}
+
+func TestReconfigurePingPeriod(t *testing.T) {
+	f := New(WithPongWait(2*time.Hour), WithPingPeriod(time.Hour))
+	conn := dial(t, serveNode(t, f), "u")
+	eventually(t, "the client", func() bool { return f.ClientLen() == 1 })
+
+	pings := make(chan struct{}, 16)
+	conn.SetPingHandler(func(string) error {
+		pings <- struct{}{}
+		return nil
+	})
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	cfg := f.Config()
+	cfg.PingPeriod = Duration(20 * time.Millisecond)
+	if err := f.Reconfigure(cfg); err != nil {
+		t.Fatal(err)
+	}
+	// The old ticker would not fire for an hour: the new period applies at once.
+	for i := 0; i < 3; i++ {
+		select {
+		case <-pings:
+		case <-time.After(time.Second):
+			t.Fatalf("ping %d not received after shortening the ping period", i)
+		}
+	}
+}
+
+func TestReconfigureRearmsTimers(t *testing.T) {
+	f := New()
+	conn := dial(t, serveNode(t, f), "u")
+	eventually(t, "the client", func() bool { return f.ClientLen() == 1 })
+
+	// The connection already outlived the new maximum lifetime, so it ends right away.
+	time.Sleep(20 * time.Millisecond)
+	cfg := f.Config()
+	cfg.MaxLifetime = Duration(10 * time.Millisecond)
+	if err := f.Reconfigure(cfg); err != nil {
+		t.Fatal(err)
+	}
+	readClose(t, conn, websocket.CloseGoingAway, "connection lifetime exceeded")
+}
@@ -0,0 +1,236 @@
+package fibril
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/contrib/websocket"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record kinds written to a recording.
+const (
+	RecordHandshake = "handshake" // Upgrade request of the connection
+	RecordInbound   = "in"        // Frame received from the client
+	RecordOutbound  = "out"       // Frame written to the client
+	RecordStream    = "stream"    // Streamed binary message, recorded without its payload
+	RecordClose     = "close"     // End of the connection
+)
+
+// RecordConfig configures connection recording. Each recorded connection is written
+// to its own JSONL file in Dir, one Record per line.
+type RecordConfig struct {
+	// Dir is the directory the recordings are written to. It is created if needed.
+	Dir string
+
+	// SampleRate is the fraction of selected connections recorded, between 0 and 1.
+	// Required. 0 records nothing and 1 records every selected connection.
+	SampleRate float64
+
+	// Keys restricts recording to clients whose keys hold all the given values,
+	// evaluated after the connect handler has run.
+	// Optional. Default: nil, which selects every connection.
+	Keys map[any]any
+
+	// AllowHeaders lists credential headers recorded as is. Authorization,
+	// Proxy-Authorization, Cookie and common token headers are otherwise
+	// replaced with RecordRedacted.
+	// Optional. Default: nil
+	AllowHeaders []string
+
+	// RedactHeaders lists further headers to replace with RecordRedacted,
+	// e.g. a custom authentication header.
+	// Optional. Default: nil
+	RedactHeaders []string
+
+	// AllowCookies lists cookies recorded as is. The values of all other
+	// cookies are replaced with RecordRedacted.
+	// Optional. Default: nil
+	AllowCookies []string
+
+	// RedactQuery lists query parameters to replace with RecordRedacted,
+	// e.g. a token passed in the URL by browser clients.
+	// Optional. Default: nil
+	RedactQuery []string
+}
+
+// RecordRedacted replaces the values of credentials in recordings.
+const RecordRedacted = "[REDACTED]"
+
+// credentialHeaders lists the request headers redacted in recordings unless
+// allowed by RecordConfig.AllowHeaders.
+var credentialHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+	"X-Access-Token",
+	"X-Session-Token",
+	"X-Csrf-Token",
+	"X-Xsrf-Token",
+}
+
+// Record is a single line of a recording.
+type Record struct {
+	Time      time.Time        `json:"time"`                // When the event happened
+	Kind      string           `json:"kind"`                // One of the Record* kinds
+	UUID      string           `json:"uuid,omitempty"`      // Client UUID, set on the handshake record
+	Type      int              `json:"type,omitempty"`      // WebSocket message type of a frame
+	Text      string           `json:"text,omitempty"`      // Payload of a text frame
+	Data      []byte           `json:"data,omitempty"`      // Payload of a binary frame, base64 encoded
+	Code      int              `json:"code,omitempty"`      // Close code of a close frame
+	Handshake *RecordedRequest `json:"handshake,omitempty"` // Upgrade request, set on the handshake record
+	Error     string           `json:"error,omitempty"`     // Disconnect cause, set on the close record
+}
+
+// RecordedRequest is the upgrade request stored in the handshake record.
+type RecordedRequest struct {
+	Headers     map[string]string `json:"headers,omitempty"`
+	Query       map[string]string `json:"query,omitempty"`
+	Cookies     map[string]string `json:"cookies,omitempty"`
+	Params      map[string]string `json:"params,omitempty"`
+	Subprotocol string            `json:"subprotocol,omitempty"`
+	RealIP      string            `json:"real_ip,omitempty"`
+}
+
+// recorder writes the frames of one connection to its recording file.
+// Frames are recorded from both the read and the write pump.
+type recorder struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+	enc  *json.Encoder
+}
+
+// startRecording opens a recording for the client if it is selected by the
+// configuration, and writes its handshake record.
+func (c *Client) startRecording() {
+	cfg := c.opt().recordConfig
+	if cfg == nil {
+		return
+	}
+	if rand.Float64() >= cfg.SampleRate {
+		return
+	}
+	for k, want := range cfg.Keys {
+		if got, ok := c.GetKey(k); !ok || got != want {
+			return
+		}
+	}
+
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		c.reportError(err)
+		return
+	}
+	name := fmt.Sprintf("%s-%s.jsonl", c.connectedAt.UTC().Format("20060102T150405.000000000"), c.uuid)
+	file, err := os.Create(filepath.Join(cfg.Dir, name))
+	if err != nil {
+		c.reportError(err)
+		return
+	}
+
+	w := bufio.NewWriter(file)
+	c.recorder = &recorder{file: file, w: w, enc: json.NewEncoder(w)}
+
+	h := c.handshake
+	c.record(Record{Kind: RecordHandshake, UUID: c.uuid, Handshake: &RecordedRequest{
+		Headers: redact(h.Headers(), func(name string) bool {
+			return (containsFold(credentialHeaders, name) || containsFold(cfg.RedactHeaders, name)) &&
+				!containsFold(cfg.AllowHeaders, name)
+		}),
+		Query: redact(h.Queries(), func(name string) bool {
+			return slices.Contains(cfg.RedactQuery, name)
+		}),
+		Cookies: redact(h.Cookies(), func(name string) bool {
+			return !slices.Contains(cfg.AllowCookies, name)
+		}),
+		Params:      h.Params(),
+		Subprotocol: h.Subprotocol(),
+		RealIP:      h.RealIP(),
+	}})
+}
+
+// redact replaces the values of the entries selected by match with RecordRedacted.
+func redact(values map[string]string, match func(name string) bool) map[string]string {
+	for name := range values {
+		if match(name) {
+			values[name] = RecordRedacted
+		}
+	}
+	return values
+}
+
+// containsFold reports whether names contains name, ignoring case.
+func containsFold(names []string, name string) bool {
+	return slices.ContainsFunc(names, func(n string) bool {
+		return strings.EqualFold(n, name)
+	})
+}
+
+// recordFrame records a data frame in the given direction.
+func (c *Client) recordFrame(kind string, t int, msg []byte) {
+	if c.recorder == nil {
+		return
+	}
+	r := Record{Kind: kind, Type: t}
+	if t == websocket.TextMessage {
+		r.Text = string(msg)
+	} else {
+		r.Data = msg
+	}
+	c.record(r)
+}
+
+// record timestamps and writes a record. Write errors stop the recording
+// but never affect the connection.
+func (c *Client) record(r Record) {
+	rec := c.recorder
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if rec.enc == nil {
+		return
+	}
+	r.Time = time.Now()
+	if err := rec.enc.Encode(r); err != nil {
+		rec.closeLocked()
+		c.reportError(err)
+	}
+}
+
+// stopRecording writes the close record and closes the recording file.
+func (c *Client) stopRecording() {
+	rec := c.recorder
+	if rec == nil {
+		return
+	}
+	r := Record{Kind: RecordClose}
+	if cause := c.Cause(); cause != nil {
+		r.Error = cause.Error()
+	}
+	c.record(r)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.closeLocked()
+}
+
+// closeLocked flushes and closes the recording file. rec.mu must be held.
+func (rec *recorder) closeLocked() {
+	if rec.enc == nil {
+		return
+	}
+	rec.enc = nil
+	_ = rec.w.Flush()
+	_ = rec.file.Close()
+}
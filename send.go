@@ -0,0 +1,72 @@
+package fibril
+
+import (
+	"context"
+	"github.com/gofiber/contrib/websocket"
+)
+
+// sendConfig holds the settings of a context-aware send.
+type sendConfig struct {
+	priority    Priority // Delivery priority of the message
+	waitWritten bool     // Whether to wait until the frame is written instead of queued
+}
+
+// SendOption customizes a context-aware send such as SendTextContext.
+type SendOption func(*sendConfig)
+
+// WithSendPriority sets the delivery priority of the message.
+func WithSendPriority(p Priority) SendOption {
+	return func(c *sendConfig) {
+		c.priority = p
+	}
+}
+
+// WithWaitWritten makes the send wait until the frame has been written to the
+// connection, returning the write error if any, instead of returning once queued.
+func WithWaitWritten() SendOption {
+	return func(c *sendConfig) {
+		c.waitWritten = true
+	}
+}
+
+// SendTextContext sends a text message to the client, blocking until it is queued
+// (or written, with WithWaitWritten). Unlike SendText it never drops the message:
+// it returns ctx.Err(), ErrClientClosed or the write error instead.
+func (c *Client) SendTextContext(ctx context.Context, msg string, opts ...SendOption) error {
+	return c.sendContext(ctx, box{t: websocket.TextMessage, msg: []byte(msg)}, opts)
+}
+
+// SendBinaryContext sends a binary message to the client, blocking until it is queued
+// (or written, with WithWaitWritten). Unlike SendBinary it never drops the message:
+// it returns ctx.Err(), ErrClientClosed or the write error instead.
+func (c *Client) SendBinaryContext(ctx context.Context, msg []byte, opts ...SendOption) error {
+	return c.sendContext(ctx, box{t: websocket.BinaryMessage, msg: msg}, opts)
+}
+
+// sendContext queues a message, waiting for queue space and optionally for the write.
+func (c *Client) sendContext(ctx context.Context, message box, opts []SendOption) error {
+	var cfg sendConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	message.priority = cfg.priority
+	if cfg.waitWritten {
+		message.done = make(chan error, 1)
+	}
+	if err := c.enqueueContext(ctx, message); err != nil {
+		return err
+	}
+	if message.done == nil {
+		return nil
+	}
+
+	select {
+	case err := <-message.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.Done():
+		return ErrClientClosed
+	}
+}
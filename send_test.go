@@ -0,0 +1,85 @@
+package fibril
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSendContextFullQueue(t *testing.T) {
+	f := New(WithMessageBufferSize(1))
+	errs := make(chan error, 3)
+	// The write pump starts after the connect handler, so the queue stays full here.
+	f.ConnectHandler(func(c *Client) {
+		_ = c.SendText("fill")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		errs <- c.SendTextContext(ctx, "deadline")
+
+		ctx, cancel = context.WithCancel(context.Background())
+		cancel()
+		errs <- c.SendTextContext(ctx, "canceled")
+
+		errs <- c.SendTextContext(context.Background(), "high", WithSendPriority(PriorityHigh))
+	})
+	conn := dial(t, serveNode(t, f), "u")
+
+	for _, want := range []error{context.DeadlineExceeded, context.Canceled, nil} {
+		if err := <-errs; !errors.Is(err, want) {
+			t.Fatalf("SendTextContext = %v, want %v", err, want)
+		}
+	}
+	for _, want := range []string{"high", "fill"} {
+		if msg := readText(t, conn); msg != want {
+			t.Fatalf("received %q, want %q", msg, want)
+		}
+	}
+}
+
+func TestSendContextWaitWritten(t *testing.T) {
+	f := New()
+	clients := make(chan *Client, 1)
+	f.ConnectHandler(func(c *Client) { clients <- c })
+	conn := dial(t, serveNode(t, f), "u")
+	c := <-clients
+
+	if err := c.SendTextContext(context.Background(), "written", WithWaitWritten()); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readText(t, conn); msg != "written" {
+		t.Fatalf("received %q, want %q", msg, "written")
+	}
+}
+
+func TestSendContextClosed(t *testing.T) {
+	f := New()
+	errs := make(chan error, 1)
+	// The close frame overtakes the normal-priority message, which is never written.
+	f.ConnectHandler(func(c *Client) {
+		c.Disconnect("bye")
+		go func() { errs <- c.SendTextContext(context.Background(), "late", WithWaitWritten()) }()
+	})
+	dial(t, serveNode(t, f), "u")
+
+	if err := <-errs; err != ErrClientClosed {
+		t.Fatalf("SendTextContext after Disconnect = %v, want ErrClientClosed", err)
+	}
+}
+
+func TestEnqueueContextAfterClose(t *testing.T) {
+	f := New()
+	clients := make(chan *Client, 1)
+	f.ConnectHandler(func(c *Client) { clients <- c })
+	dial(t, serveNode(t, f), "u")
+	c := <-clients
+
+	c.close()
+	// The queue has room, but nothing will write the message any more.
+	for i := 0; i < 10; i++ {
+		if err := c.enqueueContext(context.Background(), box{msg: []byte("x")}); err != ErrClientClosed {
+			t.Fatalf("enqueueContext on a closed client = %v, want ErrClientClosed", err)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package fibril
+
+import "slices"
+
+// SessionPolicy defines how connections sharing the same value of an identity key are handled.
+type SessionPolicy int
+
+const (
+	SessionAllowMultiple SessionPolicy = iota // Any number of connections may share the same value
+	SessionKickOldest                         // The oldest connections are disconnected to make room for the new one
+	SessionRejectNewest                       // The new connection is rejected once the limit is reached
+)
+
+// sessionRule is the uniqueness policy enforced for one identity key.
+type sessionRule struct {
+	policy      SessionPolicy // How to resolve a conflict
+	max         int           // Maximum number of concurrent connections per key value
+	closeReason string        // Close message sent to the kicked or rejected connection
+}
+
+// admit decides whether a new client may join the existing clients sharing its key value.
+// It returns false if the new client must be rejected, otherwise the existing
+// clients that have to be kicked to stay within the limit (oldest first).
+func (r sessionRule) admit(existing map[string]*Client) (bool, []*Client) {
+	if r.policy == SessionAllowMultiple || len(existing) < r.max {
+		return true, nil
+	}
+	if r.policy == SessionRejectNewest {
+		return false, nil
+	}
+
+	clients := make([]*Client, 0, len(existing))
+	for _, c := range existing {
+		clients = append(clients, c)
+	}
+	slices.SortFunc(clients, func(a, b *Client) int {
+		return a.connectedAt.Compare(b.connectedAt)
+	})
+
+	return true, clients[:len(clients)-r.max+1]
+}
@@ -0,0 +1,108 @@
+package fibril
+
+import (
+	"net/http"
+	"testing"
+
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/gofiber/contrib/websocket"
+)
+
+func TestSessionPolicyHandshake(t *testing.T) {
+	tests := []struct {
+		name   string
+		opt    OptFunc
+		status int // Status of the refused upgrade, or 101 if the oldest session is kicked
+	}{
+		{"reject-newest", WithSessionPolicy("user", SessionRejectNewest, 2, "full"), http.StatusConflict},
+		{"kick-oldest", WithSessionPolicy("user", SessionKickOldest, 2, "elsewhere"), http.StatusSwitchingProtocols},
+		{"max-per-key", WithMaxConnectionsPerKey("user", 2), http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := New(tt.opt)
+			url := serveNode(t, f)
+
+			first := dial(t, url, "alice")
+			eventually(t, "the first session", func() bool { return len(f.ClientsByKey("user", "alice")) == 1 })
+			second := dial(t, url, "alice")
+			eventually(t, "the second session", func() bool { return len(f.ClientsByKey("user", "alice")) == 2 })
+			dial(t, url, "bob") // Other values are not affected.
+
+			if status, _ := dialStatus(t, url+"?user=alice"); status != tt.status {
+				t.Fatalf("third upgrade = %d, want %d", status, tt.status)
+			}
+			if tt.status == http.StatusSwitchingProtocols {
+				readClose(t, first, websocket.ClosePolicyViolation, "elsewhere")
+			}
+			eventually(t, "two sessions of alice", func() bool { return len(f.ClientsByKey("user", "alice")) == 2 })
+			if err := f.SendTextToKey("user", "alice", "still here"); err != nil {
+				t.Fatal(err)
+			}
+			if msg := readText(t, second); msg != "still here" {
+				t.Fatalf("second session received %q", msg)
+			}
+		})
+	}
+}
+
+func TestSessionPolicyStoreKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		opt    OptFunc
+		kicked int // Index of the client disconnected by the second StoreKey
+		code   int
+		reason string
+		stored bool // Whether the second client holds the key afterwards
+	}{
+		{"reject-newest", WithSessionPolicy("user", SessionRejectNewest, 1, "full"), 1, websocket.ClosePolicyViolation, "full", false},
+		{"kick-oldest", WithSessionPolicy("user", SessionKickOldest, 1, "elsewhere"), 0, websocket.ClosePolicyViolation, "elsewhere", true},
+		{"max-per-key", WithMaxConnectionsPerKey("user", 1), 1, websocket.CloseTryAgainLater, ErrTooManyConnectionsPerKey.Error(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := New(tt.opt)
+			clients := make(chan *Client, 2)
+			f.ConnectHandler(func(c *Client) { clients <- c })
+			// The plain handler stores no keys: the clients authenticate after connecting.
+			url := serve(t, f.Handler())
+
+			var conns [2]*fasthttpws.Conn
+			var cs [2]*Client
+			for i := range conns {
+				conns[i] = dial(t, url, "")
+				cs[i] = <-clients
+			}
+			cs[0].StoreKey("user", "alice")
+			cs[1].StoreKey("user", "alice")
+
+			readClose(t, conns[tt.kicked], tt.code, tt.reason)
+			if _, ok := cs[1].GetKey("user"); ok != tt.stored {
+				t.Fatalf("second client holds the key = %t, want %t", ok, tt.stored)
+			}
+			eventually(t, "one session of alice", func() bool { return len(f.ClientsByKey("user", "alice")) == 1 })
+			if c := f.ClientsByKey("user", "alice")[0]; c != cs[1-tt.kicked] {
+				t.Fatal("the index holds the disconnected client")
+			}
+		})
+	}
+}
+
+func TestSessionPolicyRegistration(t *testing.T) {
+	f := New(WithSessionPolicy("user", SessionRejectNewest, 1, "full"))
+	// RegisterClientWithKeys has no pre-upgrade check: the policy applies on registration.
+	url := serve(t, websocket.New(func(conn *websocket.Conn) {
+		f.RegisterClientWithKeys(conn, map[any]any{"user": "alice"})
+	}))
+
+	first := dial(t, url, "")
+	eventually(t, "the first session", func() bool { return len(f.ClientsByKey("user", "alice")) == 1 })
+	readClose(t, dial(t, url, ""), websocket.ClosePolicyViolation, "full")
+
+	if err := f.SendTextToKey("user", "alice", "kept"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readText(t, first); msg != "kept" {
+		t.Fatalf("first session received %q", msg)
+	}
+}
@@ -0,0 +1,27 @@
+package fibril
+
+// ConnState describes where a client is in its connection lifecycle.
+type ConnState int32
+
+const (
+	StateConnecting ConnState = iota // Client is being set up and is not yet registered with the hub
+	StateOpen                        // Client is registered and can send and receive messages
+	StateClosing                     // A disconnect has been requested or the connection is being torn down
+	StateClosed                      // Connection is closed and the client has been removed from the hub
+)
+
+// String returns a human-readable name for the connection state.
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateOpen:
+		return "open"
+	case StateClosing:
+		return "closing"
+	case StateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,71 @@
+package fibril
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	fasthttpws "github.com/fasthttp/websocket"
+)
+
+func TestClientLifecycle(t *testing.T) {
+	tests := []struct {
+		name    string
+		close   func(*Client, *fasthttpws.Conn)
+		isCause func(error) bool
+	}{
+		{
+			name:    "server",
+			close:   func(c *Client, _ *fasthttpws.Conn) { c.Disconnect("bye") },
+			isCause: func(err error) bool { return errors.Is(err, ErrServerDisconnect) },
+		},
+		{
+			name: "peer",
+			close: func(_ *Client, conn *fasthttpws.Conn) {
+				_ = conn.WriteMessage(fasthttpws.CloseMessage, fasthttpws.FormatCloseMessage(fasthttpws.CloseGoingAway, "tab closed"))
+			},
+			isCause: func(err error) bool { return fasthttpws.IsCloseError(err, fasthttpws.CloseGoingAway) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := New()
+			clients := make(chan *Client, 1)
+			f.ConnectHandler(func(c *Client) {
+				if s := c.State(); s != StateOpen {
+					t.Errorf("state in the connect handler = %v, want open", s)
+				}
+				clients <- c
+			})
+			disconnected := make(chan ConnState, 1)
+			f.DisconnectHandler(func(c *Client) {
+				// The context is canceled before the disconnect handler runs.
+				if c.Context().Err() == nil {
+					t.Error("context still live in the disconnect handler")
+				}
+				disconnected <- c.State()
+			})
+			conn := dial(t, serveNode(t, f), "u")
+			c := <-clients
+
+			if c.Cause() != nil {
+				t.Fatalf("cause = %v while connected, want nil", c.Cause())
+			}
+			select {
+			case <-c.Done():
+				t.Fatal("Done closed while connected")
+			default:
+			}
+
+			tt.close(c, conn)
+			<-c.Done()
+			if !tt.isCause(c.Cause()) || context.Cause(c.Context()) != c.Cause() {
+				t.Fatalf("cause = %v, context cause = %v", c.Cause(), context.Cause(c.Context()))
+			}
+			if s := <-disconnected; s != StateClosing {
+				t.Fatalf("state in the disconnect handler = %v, want closing", s)
+			}
+			eventually(t, "the closed state", func() bool { return c.State() == StateClosed })
+		})
+	}
+}
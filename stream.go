@@ -0,0 +1,77 @@
+package fibril
+
+import (
+	"bytes"
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/gofiber/contrib/websocket"
+	"io"
+	"time"
+)
+
+// handleStreamFunc defines a function type for consuming a large binary message as a stream.
+type handleStreamFunc func(*Client, io.Reader) error
+
+// readMessageOrStream reads the next message through the connection's reader API.
+// Messages up to maxMessageSize are buffered and returned for the regular handlers.
+// Larger binary messages are handed to the binary stream handler without buffering,
+// in which case the returned message type is 0.
+func (c *Client) readMessageOrStream() (int, []byte, error) {
+	t, r, err := c.conn.NextReader()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	head, err := io.ReadAll(io.LimitReader(r, c.opt().maxMessageSize+1))
+	if err != nil {
+		return 0, nil, err
+	}
+	if int64(len(head)) <= c.opt().maxMessageSize {
+		return t, head, nil
+	}
+
+	if t != websocket.BinaryMessage {
+		_ = c.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseMessageTooBig, ""), time.Now().Add(c.opt().writeWait))
+		return 0, nil, fasthttpws.ErrReadLimit
+	}
+
+	c.stream(io.MultiReader(bytes.NewReader(head), r))
+	return 0, nil, nil
+}
+
+// stream passes a large binary message to the binary stream handler, applying the
+// per-stream timeout. The connection's read limit caps the total stream size, so a
+// reader that exceeds maxStreamSize returns an error and the connection is closed.
+func (c *Client) stream(r io.Reader) {
+	if c.opt().streamTimeout > 0 {
+		c.streamDeadline = time.Now().Add(c.opt().streamTimeout)
+		_ = c.conn.SetReadDeadline(c.streamDeadline)
+	}
+	defer func() {
+		c.streamDeadline = time.Time{}
+		_ = c.conn.SetReadDeadline(c.readDeadline())
+	}()
+
+	c.record(Record{Kind: RecordStream, Type: websocket.BinaryMessage})
+	c.touch()
+	handler := c.opt().binaryStreamHandler
+	if handler == nil {
+		// The handler was removed after the client connected: discard the message.
+		_, _ = io.Copy(io.Discard, r)
+		c.reportError(fasthttpws.ErrReadLimit)
+		return
+	}
+	if err := handler(c, r); err != nil {
+		c.reportError(err)
+	}
+}
+
+// readDeadline returns the read deadline after a liveness signal, which never
+// extends past the deadline of a stream in progress.
+func (c *Client) readDeadline() time.Time {
+	deadline := time.Now().Add(c.opt().pongWait)
+	if !c.streamDeadline.IsZero() && c.streamDeadline.Before(deadline) {
+		return c.streamDeadline
+	}
+	return deadline
+}
@@ -0,0 +1,97 @@
+package fibril
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	fasthttpws "github.com/fasthttp/websocket"
+)
+
+func TestBinaryStreamHandler(t *testing.T) {
+	f := New(WithMaxMessageSize(1024), WithMaxStreamSize(64*1024))
+	streams := make(chan []byte, 1)
+	binaries := make(chan []byte, 1)
+	texts := make(chan string, 1)
+	f.BinaryStreamHandler(func(c *Client, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		streams <- data
+		return err
+	})
+	f.BinaryMessageHandler(func(c *Client, msg []byte) { binaries <- msg })
+	f.TextMessageHandler(func(c *Client, msg string) { texts <- msg })
+	conn := dial(t, serveNode(t, f), "alice")
+
+	large := bytes.Repeat([]byte("0123456789"), 4096)
+	if err := conn.WriteMessage(fasthttpws.BinaryMessage, large); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-streams:
+		if !bytes.Equal(got, large) {
+			t.Fatalf("streamed %d bytes, want the %d bytes sent", len(got), len(large))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("large binary message was not streamed")
+	}
+
+	// Messages within the message size limit still reach the regular handlers.
+	if err := conn.WriteMessage(fasthttpws.BinaryMessage, []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-binaries:
+		if string(got) != "small" {
+			t.Fatalf("binary handler got %q, want small", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("small binary message did not reach BinaryMessageHandler")
+	}
+	if err := conn.WriteMessage(fasthttpws.TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-texts:
+		if got != "hello" {
+			t.Fatalf("text handler got %q, want hello", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("text message did not reach TextMessageHandler")
+	}
+	select {
+	case <-streams:
+		t.Fatal("a small message was streamed")
+	default:
+	}
+
+	// Text messages are never streamed: a large one closes the connection.
+	if err := conn.WriteMessage(fasthttpws.TextMessage, large); err != nil {
+		t.Fatal(err)
+	}
+	readClose(t, conn, fasthttpws.CloseMessageTooBig, "")
+}
+
+func TestBinaryStreamMaxSize(t *testing.T) {
+	f := New(WithMaxMessageSize(1024), WithMaxStreamSize(8*1024))
+	errs := make(chan error, 1)
+	f.BinaryStreamHandler(func(c *Client, r io.Reader) error {
+		_, err := io.Copy(io.Discard, r)
+		errs <- err
+		return err
+	})
+	conn := dial(t, serveNode(t, f), "alice")
+
+	if err := conn.WriteMessage(fasthttpws.BinaryMessage, make([]byte, 16*1024)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		if err != fasthttpws.ErrReadLimit {
+			t.Fatalf("stream read ended with %v, want %v", err, fasthttpws.ErrReadLimit)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("oversized stream was not cut off")
+	}
+	readClose(t, conn, fasthttpws.CloseMessageTooBig, "")
+}
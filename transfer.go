@@ -0,0 +1,122 @@
+package fibril
+
+import (
+	"context"
+	"github.com/gofiber/contrib/websocket"
+	"io"
+	"math/rand/v2"
+)
+
+// defaultTransferChunkSize is the payload size of each chunk sent by SendStream.
+const defaultTransferChunkSize = 32 << 10
+
+// transferConfig holds the settings of a single outbound chunked transfer.
+type transferConfig struct {
+	id        uint64 // Transfer ID, random unless resuming
+	offset    int64  // Byte offset to start sending from
+	chunkSize int    // Payload size of each chunk
+}
+
+// TransferOption customizes a chunked transfer sent with SendStream.
+type TransferOption func(*transferConfig)
+
+// WithTransferID sets the transfer ID, typically to resume an earlier transfer.
+func WithTransferID(id uint64) TransferOption {
+	return func(c *transferConfig) {
+		c.id = id
+	}
+}
+
+// WithTransferOffset starts the transfer at the given byte offset, used to resume an
+// interrupted transfer. If the reader is an io.Seeker it is seeked, otherwise the
+// leading bytes are read and discarded.
+func WithTransferOffset(offset int64) TransferOption {
+	return func(c *transferConfig) {
+		c.offset = max(offset, 0)
+	}
+}
+
+// WithTransferChunkSize sets the payload size of each chunk. Values below 1 KiB default to 1 KiB.
+func WithTransferChunkSize(size int) TransferOption {
+	return func(c *transferConfig) {
+		c.chunkSize = max(size, 1<<10)
+	}
+}
+
+// SendStream sends the contents of r to the client as a chunked binary transfer.
+// Each chunk is a binary frame prefixed with a ChunkHeader; the first frame carries the
+// transfer name and the last one has the ChunkFinal flag. Chunks are queued at low
+// priority, one at a time: each is written before the next is read from r, so a
+// transfer holds at most one chunk in memory and interleaves with the client's normal
+// traffic instead of blocking it. If ctx is canceled or r fails, an abort frame is sent
+// best-effort and the error is returned.
+func (c *Client) SendStream(ctx context.Context, name string, r io.Reader, opts ...TransferOption) error {
+	cfg := transferConfig{id: rand.Uint64(), chunkSize: defaultTransferChunkSize}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.offset > 0 {
+		if err := skip(r, cfg.offset); err != nil {
+			return err
+		}
+	}
+
+	h := ChunkHeader{TransferID: cfg.id, Offset: cfg.offset, Flags: ChunkStart}
+	if err := c.sendChunk(ctx, h, []byte(name)); err != nil {
+		return err
+	}
+
+	buf := make([]byte, cfg.chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		final := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !final {
+			c.abortStream(h)
+			return err
+		}
+		// Reading r may have blocked for a while: don't queue a chunk once canceled.
+		if err := ctx.Err(); err != nil {
+			c.abortStream(h)
+			return err
+		}
+
+		h.Sequence++
+		h.Flags = 0
+		if final {
+			h.Flags = ChunkFinal
+		}
+		if err := c.sendChunk(ctx, h, buf[:n]); err != nil {
+			c.abortStream(h)
+			return err
+		}
+		if final {
+			return nil
+		}
+		h.Offset += int64(n)
+	}
+}
+
+// sendChunk queues a single chunk frame at low priority and waits until it is written.
+func (c *Client) sendChunk(ctx context.Context, h ChunkHeader, payload []byte) error {
+	frame := appendChunk(make([]byte, 0, ChunkHeaderSize+len(payload)), h, payload)
+	return c.sendContext(ctx, box{t: websocket.BinaryMessage, msg: frame},
+		[]SendOption{WithSendPriority(PriorityLow), WithWaitWritten()})
+}
+
+// abortStream queues an abort frame for the transfer without waiting.
+func (c *Client) abortStream(h ChunkHeader) {
+	h.Sequence++
+	h.Flags = ChunkAbort
+	c.enqueue(box{t: websocket.BinaryMessage, msg: appendChunk(nil, h, nil), priority: PriorityLow})
+}
+
+// skip advances r by n bytes, seeking when possible.
+func skip(r io.Reader, n int64) error {
+	if s, ok := r.(io.Seeker); ok {
+		_, err := s.Seek(n, io.SeekCurrent)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, r, n)
+	return err
+}
@@ -0,0 +1,160 @@
+package fibril
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	fasthttpws "github.com/fasthttp/websocket"
+)
+
+// streamClient serves f and returns a connected peer and its server-side client.
+func streamClient(t *testing.T, f *Fibril) (*fasthttpws.Conn, *Client) {
+	t.Helper()
+
+	clients := make(chan *Client, 1)
+	f.ConnectHandler(func(c *Client) { clients <- c })
+	conn := dial(t, serveNode(t, f), "u")
+	return conn, <-clients
+}
+
+// readChunk reads the next frame from conn as a chunk.
+func readChunk(t *testing.T, conn *fasthttpws.Conn) (ChunkHeader, []byte) {
+	t.Helper()
+
+	_, frame := readMessage(t, conn)
+	h, payload, err := ParseChunk([]byte(frame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, payload
+}
+
+// onlyReader hides any io.Seeker implementation of r.
+type onlyReader struct{ io.Reader }
+
+func TestSendStream(t *testing.T) {
+	data := make([]byte, 10<<10+100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	tests := []struct {
+		name   string
+		r      io.Reader
+		offset int64
+	}{
+		{"whole", bytes.NewReader(data), 0},
+		{"resume-seeker", bytes.NewReader(data), 3000},
+		{"resume-reader", onlyReader{bytes.NewReader(data)}, 3000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, c := streamClient(t, New())
+			errs := make(chan error, 1)
+			go func() {
+				errs <- c.SendStream(context.Background(), "data.bin", tt.r,
+					WithTransferID(42), WithTransferOffset(tt.offset), WithTransferChunkSize(1<<10))
+			}()
+
+			h, name := readChunk(t, conn)
+			if h.Flags != ChunkStart || h.TransferID != 42 || h.Offset != tt.offset || string(name) != "data.bin" {
+				t.Fatalf("start frame = %+v %q", h, name)
+			}
+			var got []byte
+			for seq := uint32(1); ; seq++ {
+				h, payload := readChunk(t, conn)
+				if h.Sequence != seq || h.Offset != tt.offset+int64(len(got)) {
+					t.Fatalf("chunk %d = %+v, want offset %d", seq, h, tt.offset+int64(len(got)))
+				}
+				// Every chunk is written before the next is queued.
+				if n := c.QueueLen(); n > 1 {
+					t.Fatalf("queue length = %d during the transfer, want at most 1", n)
+				}
+				got = append(got, payload...)
+				if h.Flags == ChunkFinal {
+					break
+				}
+				if len(payload) != 1<<10 {
+					t.Fatalf("chunk %d has %d bytes, want 1024", seq, len(payload))
+				}
+			}
+			if !bytes.Equal(got, data[tt.offset:]) {
+				t.Fatalf("received %d bytes that differ from the source", len(got))
+			}
+			if err := <-errs; err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// gatedReader returns one chunk of data, then blocks until release is closed and
+// returns err, or more data if err is nil.
+type gatedReader struct {
+	sent    bool
+	release chan struct{}
+	err     error
+}
+
+func (r *gatedReader) Read(p []byte) (int, error) {
+	if r.sent {
+		<-r.release
+		if r.err != nil {
+			return 0, r.err
+		}
+	}
+	r.sent = true
+	return copy(p, bytes.Repeat([]byte{1}, 1<<10)), nil
+}
+
+func TestSendStreamAbort(t *testing.T) {
+	conn, c := streamClient(t, New())
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &gatedReader{release: make(chan struct{})}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.SendStream(ctx, "data.bin", r, WithTransferID(7), WithTransferChunkSize(1<<10))
+	}()
+
+	// Cancel while the transfer is blocked reading the second chunk.
+	readChunk(t, conn)
+	readChunk(t, conn)
+	cancel()
+	close(r.release)
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("SendStream = %v, want context.Canceled", err)
+	}
+	for {
+		h, _ := readChunk(t, conn)
+		if h.Flags == ChunkAbort {
+			if h.TransferID != 7 {
+				t.Fatalf("abort frame = %+v", h)
+			}
+			return
+		}
+	}
+}
+
+func TestSendStreamReaderError(t *testing.T) {
+	conn, c := streamClient(t, New())
+	r := &gatedReader{release: make(chan struct{}), err: errors.New("reader closed")}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.SendStream(context.Background(), "data.bin", r, WithTransferChunkSize(1<<10))
+	}()
+
+	readChunk(t, conn)
+	readChunk(t, conn)
+	close(r.release)
+	if err := <-errs; err == nil || err.Error() != "reader closed" {
+		t.Fatalf("SendStream = %v, want the reader's error", err)
+	}
+	for {
+		if h, _ := readChunk(t, conn); h.Flags == ChunkAbort {
+			return
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package fibril
+
+import (
+	"encoding/json"
+	"github.com/gofiber/contrib/websocket"
+)
+
+// drain collects b and up to writeBatchSize-1 further queued messages without blocking,
+// in priority order, so writePump can write everything that is already waiting in one pass.
+// A close message ends the batch since nothing may be written after it.
+func (c *Client) drain(b box) []box {
+	clear(c.batch)
+	c.batch = append(c.batch[:0], b)
+	for len(c.batch) < c.opt().writeBatchSize && b.t != websocket.CloseMessage {
+		var ok bool
+		if b, ok = c.next(); !ok {
+			break
+		}
+		c.batch = append(c.batch, b)
+	}
+	return c.batch
+}
+
+// writeBatch writes a batch of queued messages. Runs of consecutive JSON text messages
+// are coalesced into a single JSON-array frame when the client has opted in.
+// It reports whether a close message was written, after which the pump must stop.
+func (c *Client) writeBatch(batch []box) (bool, error) {
+	for i := 0; i < len(batch); i++ {
+		b := batch[i]
+
+		if b.t == websocket.CloseMessage {
+			code := b.code
+			if code == 0 {
+				code = websocket.CloseNormalClosure
+			}
+			if err := c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, string(b.msg))); err != nil {
+				c.reportError(err)
+			}
+			c.record(Record{Kind: RecordOutbound, Type: websocket.CloseMessage, Code: code, Text: string(b.msg)})
+			return true, nil
+		}
+
+		n := 1
+		if c.coalesce.Load() && coalescable(b) {
+			for i+n < len(batch) && coalescable(batch[i+n]) {
+				n++
+			}
+		}
+
+		var err error
+		if n > 1 {
+			err = c.writeJSONArray(batch[i : i+n])
+		} else {
+			err = c.writeBox(b)
+		}
+		ackAll(batch[i:i+n], err)
+		if err != nil {
+			ackAll(batch[i+n:], ErrClientClosed)
+			return false, err
+		}
+		i += n - 1
+	}
+	return false, nil
+}
+
+// coalescable reports whether b may be merged into a JSON-array frame. Application-level
+// heartbeats are always written on their own so clients can recognize them.
+func coalescable(b box) bool {
+	return b.t == websocket.TextMessage && !b.keepalive && json.Valid(b.msg)
+}
+
+// ackAll reports err to every sender waiting on one of the given messages.
+func ackAll(batch []box, err error) {
+	for _, b := range batch {
+		b.ack(err)
+	}
+}
+
+// writeBox writes a single data message, using the prepared frame when available.
+func (c *Client) writeBox(b box) error {
+	var err error
+	if b.pm != nil {
+		err = c.conn.WritePreparedMessage(b.pm)
+	} else {
+		err = c.conn.WriteMessage(b.t, b.msg)
+	}
+	if err == nil {
+		c.recordFrame(RecordOutbound, b.t, b.msg)
+		if !b.keepalive {
+			c.touch()
+		}
+	}
+	return err
+}
+
+// writeJSONArray writes the given JSON text messages as a single JSON-array text frame.
+func (c *Client) writeJSONArray(batch []box) error {
+	w, err := c.conn.NextWriter(websocket.TextMessage)
+	if err != nil {
+		return err
+	}
+
+	_, _ = w.Write([]byte{'['})
+	for i, b := range batch {
+		if i > 0 {
+			_, _ = w.Write([]byte{','})
+		}
+		_, _ = w.Write(b.msg)
+	}
+	_, _ = w.Write([]byte{']'})
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+	c.touch()
+	if c.recorder != nil {
+		msg := []byte{'['}
+		for i, b := range batch {
+			if i > 0 {
+				msg = append(msg, ',')
+			}
+			msg = append(msg, b.msg...)
+		}
+		c.recordFrame(RecordOutbound, websocket.TextMessage, append(msg, ']'))
+	}
+	return nil
+}
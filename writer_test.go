@@ -0,0 +1,64 @@
+package fibril
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gofiber/contrib/websocket"
+)
+
+func TestWriteBatchCoalescing(t *testing.T) {
+	large := `"` + strings.Repeat("x", 70_000) + `"`
+
+	type frame struct {
+		t   int
+		msg string
+	}
+	tests := []struct {
+		name     string
+		coalesce bool
+		want     []frame
+	}{
+		{"coalescing", true, []frame{
+			{websocket.TextMessage, `[{"a":1},{"b":2}]`},
+			{websocket.TextMessage, `not json`},
+			{websocket.TextMessage, `[3]`},
+			{websocket.BinaryMessage, `{"c":4}`},
+			{websocket.TextMessage, `[{"d":5},` + large + `]`},
+		}},
+		{"disabled", false, []frame{
+			{websocket.TextMessage, `{"a":1}`},
+			{websocket.TextMessage, `{"b":2}`},
+			{websocket.TextMessage, `not json`},
+			{websocket.TextMessage, `[3]`},
+			{websocket.BinaryMessage, `{"c":4}`},
+			{websocket.TextMessage, `{"d":5}`},
+			{websocket.TextMessage, large},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := New(WithWriteBatching(16))
+			// Messages sent from the connect handler are queued before the write pump
+			// starts, so they all land in its first batch.
+			f.ConnectHandler(func(c *Client) {
+				c.SetCoalescing(tt.coalesce)
+				_ = c.SendText(`{"a":1}`)
+				_ = c.SendText(`{"b":2}`)
+				_ = c.SendText(`not json`)
+				_ = c.SendText(`[3]`)
+				_ = c.SendBinary([]byte(`{"c":4}`))
+				_ = c.SendText(`{"d":5}`)
+				_ = c.SendText(large)
+			})
+			conn := dial(t, serveNode(t, f), "u")
+
+			for i, want := range tt.want {
+				typ, msg := readMessage(t, conn)
+				if typ != want.t || msg != want.msg {
+					t.Fatalf("frame %d = (%d, %.40q), want (%d, %.40q)", i, typ, msg, want.t, want.msg)
+				}
+			}
+		})
+	}
+}